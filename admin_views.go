@@ -0,0 +1,70 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+import (
+	"fmt"
+	"golang.org/x/net/xsrftoken"
+	"net/http"
+)
+
+// CheckWikiView shows the consistency report of the wiki and, on POST,
+// repairs the search index.
+func CheckWikiView(w http.ResponseWriter, r *vRequest) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	report, err := CheckWiki(r.Ctx.Storage, &r.Ctx.Index)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Request.Method == "POST" {
+		if err := r.Request.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		xsrf := r.Request.PostFormValue("_xsrf")
+		if xsrfValid := xsrftoken.Valid(xsrf, r.Ctx.XsrfSecret, r.AuthUser.Name, "post"); !xsrfValid {
+			http.Error(w, "Invalid XSRF token", http.StatusBadRequest)
+			return
+		}
+
+		if err = report.FixIndex(r.Ctx.Storage, &r.Ctx.Index); err != nil {
+			AddAlert(fmt.Sprintf("Error fixing the index: %s", err), "danger", r)
+		} else {
+			AddAlert("Index fixed", "success", r)
+		}
+		r.Session.Save(r.Request, w)
+		http.Redirect(w, r.Request, "/?action=check", http.StatusSeeOther)
+		return
+	}
+
+	ctx := newTemplateContext(r)
+	ctx["report"] = report
+	ctx["breadcrumbs"] = getBreadcrumbs(r)
+	ctx["alerts"] = GetAlerts(r, w)
+	ctx["_xsrf"] = xsrftoken.Generate(r.Ctx.XsrfSecret, r.AuthUser.Name, "post")
+
+	r.Ctx.RenderTemplate("check.html", ctx, w)
+}
+
+// ShowWebhookDeliveries shows the webhook delivery log.
+func ShowWebhookDeliveries(w http.ResponseWriter, r *vRequest) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	ctx := newTemplateContext(r)
+	ctx["deliveries"] = r.Ctx.Webhooks.Deliveries()
+	ctx["breadcrumbs"] = getBreadcrumbs(r)
+
+	r.Ctx.RenderTemplate("webhooks.html", ctx, w)
+}
@@ -0,0 +1,75 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"time"
+)
+
+// Supported archive formats.
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// WriteArchive writes to w an archive of the files found inside the
+// directory dir (the whole wiki when empty) at the revision rev (the last
+// commit when empty). Every file is stored under the "prefix" directory.
+func WriteArchive(w io.Writer, storage Storage, format, dir, rev, prefix string) error {
+	mtime := time.Now()
+
+	switch format {
+	case ArchiveZip:
+		zw := zip.NewWriter(w)
+		err := storage.WalkFiles(rev, dir, func(filename string, data []byte) error {
+			header := &zip.FileHeader{
+				Name:   path.Join(prefix, filename),
+				Method: zip.Deflate,
+			}
+			header.SetModTime(mtime)
+			fw, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = fw.Write(data)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return zw.Close()
+	case ArchiveTarGz:
+		gw := gzip.NewWriter(w)
+		tw := tar.NewWriter(gw)
+		err := storage.WalkFiles(rev, dir, func(filename string, data []byte) error {
+			header := &tar.Header{
+				Name:    path.Join(prefix, filename),
+				Mode:    0644,
+				Size:    int64(len(data)),
+				ModTime: mtime,
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			_, err := tw.Write(data)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if err = tw.Close(); err != nil {
+			return err
+		}
+		return gw.Close()
+	}
+
+	return fmt.Errorf("Unknown archive format: %s", format)
+}
@@ -0,0 +1,29 @@
+package spock
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWriteArchive(t *testing.T) {
+	gs := createTestRepo(t)
+	defer cleanup(t, gs)
+
+	checkFatal(t, MkMissingDirs(gs.MakeAbsPath("notes/linux.md")))
+	createTestPage(t, gs, "index.md", "this is my index", "test user", "test@email.com", "created", time.Now())
+	createTestPage(t, gs, "notes/linux.md", "my linux notes", "test user", "test@email.com", "created", time.Now())
+
+	var buf bytes.Buffer
+	checkFatal(t, WriteArchive(&buf, gs, ArchiveZip, "notes", "", "notes"))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	checkFatal(t, err)
+	if len(zr.File) != 1 {
+		t.Fatalf("There should be 1 file in the archive, there are %d", len(zr.File))
+	}
+	if zr.File[0].Name != "notes/linux.md" {
+		t.Fatalf("File name should be \"notes/linux.md\", is \"%s\"", zr.File[0].Name)
+	}
+}
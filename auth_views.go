@@ -20,6 +20,21 @@ func isLoggedIn(r *vRequest) bool {
 	return false
 }
 
+// isVerified tests whether an incoming request comes from a user who logged
+// in with the password of an account; the email of the other users is only
+// what they typed in the login form.
+func isVerified(r *vRequest) bool {
+	return isLoggedIn(r) && r.AuthUser != nil && r.AuthUser.Verified
+}
+
+// isAdmin tests whether an incoming request comes from an administrator.
+func isAdmin(r *vRequest) bool {
+	if !isVerified(r) || r.Ctx.Config == nil {
+		return false
+	}
+	return r.Ctx.Config.IsAdmin(r.AuthUser.Email)
+}
+
 func Login(w http.ResponseWriter, r *vRequest) {
 	var error bool
 
@@ -36,9 +51,20 @@ func Login(w http.ResponseWriter, r *vRequest) {
 
 		name := r.Request.PostFormValue("name")
 		email := r.Request.PostFormValue("email")
+		password := r.Request.PostFormValue("password")
+
+		// the users having an account must log in with their password.
+		var verified bool
+		if r.Ctx.Config != nil && r.Ctx.Config.HasAccount(email) {
+			verified = r.Ctx.Config.CheckPassword(email, password)
+			if !verified {
+				email = ""
+			}
+		}
 
 		if name != "" && email != "" {
 			r.Session.Values["logged_in"] = true
+			r.Session.Values["verified"] = verified
 			r.Session.Values["name"] = name
 			r.Session.Values["email"] = email
 			r.Session.Save(r.Request, w)
@@ -58,6 +84,7 @@ func Login(w http.ResponseWriter, r *vRequest) {
 
 func Logout(w http.ResponseWriter, r *vRequest) {
 	delete(r.Session.Values, "logged_in")
+	delete(r.Session.Values, "verified")
 	delete(r.Session.Values, "name")
 	delete(r.Session.Values, "email")
 	r.Session.Save(r.Request, w)
@@ -0,0 +1,38 @@
+package spock
+
+import (
+	"github.com/gorilla/sessions"
+	"testing"
+)
+
+func TestUnverifiedUsers(t *testing.T) {
+	hash, err := HashPassword("s3cret")
+	checkFatal(t, err)
+	cfg := &Configuration{
+		Admins:   []string{"admin@example.com"},
+		Accounts: []AccountConfig{{Email: "admin@example.com", PasswordHash: hash}},
+	}
+	if !cfg.CheckPassword("admin@example.com", "s3cret") || cfg.CheckPassword("admin@example.com", "guess") {
+		t.Fatal("CheckPassword should accept only the right password")
+	}
+
+	newRequest := func(verified bool) *vRequest {
+		session := sessions.NewSession(sessions.NewCookieStore([]byte("secret")), "spock")
+		session.Values["logged_in"] = true
+		session.Values["verified"] = verified
+		session.Values["name"] = "Admin"
+		session.Values["email"] = "admin@example.com"
+		return &vRequest{Ctx: &AppContext{Config: cfg}, Session: session, AuthUser: UserFromSession(session)}
+	}
+
+	// the email typed in the login form is not enough
+	r := newRequest(false)
+	if canDecrypt(r) || isAdmin(r) {
+		t.Error("an unverified session should not decrypt pages or be an administrator")
+	}
+
+	r = newRequest(true)
+	if !canDecrypt(r) || !isAdmin(r) {
+		t.Error("a verified session should decrypt pages and be an administrator")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// Cache of the rendered pages.
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultRenderCacheSize is the number of rendered pages kept in memory
+// when the configuration doesn't specify it.
+const DefaultRenderCacheSize = 500
+
+// RenderCache keeps the HTML of the most recently rendered pages; entries
+// are keyed by the blob id of the page, its markup, its path (links and
+// includes are relative to it) and how it's sanitised, so a new version of
+// a page never hits an old entry.
+type RenderCache struct {
+	cache *lruCache
+}
+
+// NewRenderCache creates a cache holding at most size pages.
+func NewRenderCache(size int) *RenderCache {
+	return &RenderCache{cache: newLRUCache(size)}
+}
+
+func renderCacheKey(page *Page) string {
+	return page.BlobID() + ":" + page.GetMarkup() + ":" + page.Path + ":" + sanitizeState(page.Path)
+}
+
+// Render returns the HTML of page, rendering it only if it's not found in
+// the cache; the included pages are looked up with lookup, and taken from
+// the cache too.
+func (rc *RenderCache) Render(page *Page, lookup LookupFunc) ([]byte, error) {
+	rendered, err := rc.parts(page)
+	if err != nil {
+		return rendered.content, err
+	}
+	return renderIncludes(rc, lookup, page, rendered, false, nil), nil
+}
+
+// parts returns the rendering of page without the included pages.
+func (rc *RenderCache) parts(page *Page) (*renderedPage, error) {
+	if rc == nil {
+		return page.renderParts(page.Content, false)
+	}
+
+	key := renderCacheKey(page)
+	if rendered, ok := rc.get(key); ok {
+		return rendered, nil
+	}
+
+	rendered, err := page.renderParts(page.Content, false)
+	if err != nil {
+		return rendered, err
+	}
+	rc.put(key, rendered)
+	return rendered, nil
+}
+
+func (rc *RenderCache) get(key string) (*renderedPage, bool) {
+	if value, ok := rc.cache.get(key); ok {
+		return value.(*renderedPage), true
+	}
+	return nil, false
+}
+
+func (rc *RenderCache) put(key string, rendered *renderedPage) {
+	rc.cache.put(key, rendered)
+}
+
+// Len returns the number of cached pages.
+func (rc *RenderCache) Len() int {
+	return rc.cache.Len()
+}
+
+type lruCacheEntry struct {
+	key   string
+	value interface{}
+}
+
+// lruCache is a map holding at most size values; the least recently used
+// values are removed first.
+type lruCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*lruCacheEntry).value, true
+	}
+	return nil, false
+}
+
+func (c *lruCache) put(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		elem.Value.(*lruCacheEntry).value = value
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&lruCacheEntry{key, value})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruCacheEntry).key)
+	}
+}
+
+// Len returns the number of cached values.
+func (c *lruCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
@@ -0,0 +1,80 @@
+package spock
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBlobID(t *testing.T) {
+	page := NewPage("hello.md")
+	checkFatal(t, page.SetRawBytes([]byte("hello\n")))
+
+	// git hash-object of a file containing "hello\n"
+	expected := "ce013625030ba8dba906f756967f9e9ca394464a"
+	if id := page.BlobID(); id != expected {
+		t.Fatalf("blob id should be %s, is %s", expected, id)
+	}
+}
+
+func TestRenderCache(t *testing.T) {
+	rc := NewRenderCache(2)
+
+	page := NewPage("index.md")
+	checkFatal(t, page.SetRawBytes([]byte("# Hello")))
+	html, err := rc.Render(page, nil)
+	checkFatal(t, err)
+	if rc.Len() != 1 {
+		t.Fatalf("the cache should contain 1 page, contains %d", rc.Len())
+	}
+
+	cached, err := rc.Render(page, nil)
+	checkFatal(t, err)
+	if string(cached) != string(html) {
+		t.Fatalf("cached html should be %q, is %q", html, cached)
+	}
+
+	// a new version of the page must not be served from the cache
+	checkFatal(t, page.SetRawBytes([]byte("# Goodbye")))
+	html, err = rc.Render(page, nil)
+	checkFatal(t, err)
+	if string(html) == string(cached) {
+		t.Fatal("the old version of the page was returned")
+	}
+
+	other := NewPage("other.md")
+	checkFatal(t, other.SetRawBytes([]byte("Other page")))
+	_, err = rc.Render(other, nil)
+	checkFatal(t, err)
+	if rc.Len() != 2 {
+		t.Fatalf("the cache should contain 2 pages, contains %d", rc.Len())
+	}
+}
+
+func TestRenderCacheSameBlob(t *testing.T) {
+	rc := NewRenderCache(10)
+	lookup := mapLookup(t, map[string]string{
+		"a/part.md": "Part of A",
+		"b/part.md": "Part of B",
+	})
+
+	// the same file in two directories includes different pages
+	for _, dir := range []string{"a", "b"} {
+		page := NewPage(dir + "/page.md")
+		checkFatal(t, page.SetRawBytes([]byte("{{include: part}}\n")))
+		html, err := rc.Render(page, lookup)
+		checkFatal(t, err)
+		if expected := "Part of " + strings.ToUpper(dir); !strings.Contains(string(html), expected) {
+			t.Errorf("%s should contain %q: %s", page.Path, expected, html)
+		}
+	}
+}
+
+func TestNilRenderCache(t *testing.T) {
+	var rc *RenderCache
+
+	page := NewPage("index.md")
+	checkFatal(t, page.SetRawBytes([]byte("# Hello")))
+	if _, err := rc.Render(page, nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// Consistency checks between the wiki repository and the search index.
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+)
+
+// CheckProblem is a page that could not be parsed.
+type CheckProblem struct {
+	Path  string
+	Error string
+}
+
+// CheckReport lists the problems found by CheckWiki.
+type CheckReport struct {
+	// Pages whose YAML header can't be parsed.
+	BadHeaders []CheckProblem
+	// Files without a page extension.
+	UnknownFiles []string
+	// Index documents of pages that don't exist anymore.
+	StaleDocuments []string
+	// Pages missing from the index.
+	Unindexed []string
+}
+
+// IsClean returns true when no problems were found.
+func (cr *CheckReport) IsClean() bool {
+	return len(cr.BadHeaders) == 0 && len(cr.UnknownFiles) == 0 &&
+		len(cr.StaleDocuments) == 0 && len(cr.Unindexed) == 0
+}
+
+// NeedsFix returns true when the index must be repaired.
+func (cr *CheckReport) NeedsFix() bool {
+	return len(cr.StaleDocuments) > 0 || len(cr.Unindexed) > 0
+}
+
+// Print writes a human readable version of the report to w.
+func (cr *CheckReport) Print(w io.Writer) {
+	for _, problem := range cr.BadHeaders {
+		fmt.Fprintf(w, "bad header: %s: %s\n", problem.Path, problem.Error)
+	}
+	for _, filename := range cr.UnknownFiles {
+		fmt.Fprintf(w, "unknown extension: %s\n", filename)
+	}
+	for _, id := range cr.StaleDocuments {
+		fmt.Fprintf(w, "stale index document: %s\n", id)
+	}
+	for _, id := range cr.Unindexed {
+		fmt.Fprintf(w, "page not indexed: %s\n", id)
+	}
+}
+
+// CheckWiki compares the content of the wiki repository with the search
+// index.
+func CheckWiki(storage Storage, index *Index) (*CheckReport, error) {
+	report := &CheckReport{}
+
+	pages, err := storage.ListPages()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pages) > 0 {
+		err = storage.WalkFiles("", "", func(filename string, data []byte) error {
+			if !IsPageFilename(filename) {
+				report.UnknownFiles = append(report.UnknownFiles, filename)
+				return nil
+			}
+			if _, _, err := ParsePageBytes(data); err != nil {
+				report.BadHeaders = append(report.BadHeaders, CheckProblem{filename, err.Error()})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ids, err := index.DocumentIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	indexed := make(map[string]bool)
+	for _, id := range ids {
+		indexed[id] = true
+	}
+	existing := make(map[string]bool)
+	for _, page := range pages {
+		existing[page] = true
+		if !indexed[page] {
+			report.Unindexed = append(report.Unindexed, page)
+		}
+	}
+	for _, id := range ids {
+		if !existing[id] {
+			report.StaleDocuments = append(report.StaleDocuments, id)
+		}
+	}
+	sort.Strings(report.StaleDocuments)
+
+	return report, nil
+}
+
+// FixIndex removes the stale documents from the index and indexes the
+// missing pages.
+func (cr *CheckReport) FixIndex(storage Storage, index *Index) error {
+	for _, id := range cr.StaleDocuments {
+		if err := index.index.Delete(id); err != nil {
+			return err
+		}
+	}
+
+	for _, pagePath := range cr.Unindexed {
+		// pages with a broken header are listed in BadHeaders.
+		page, exists, err := storage.LookupPage(pagePath)
+		if err != nil || !exists {
+			continue
+		}
+		if err = index.AddPage(page, storage.LookupPage); err != nil {
+			log.Printf("Error indexing document %s: %s\n", page, err)
+		}
+	}
+
+	cr.StaleDocuments = nil
+	cr.Unindexed = nil
+	return nil
+}
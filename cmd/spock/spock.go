@@ -5,6 +5,7 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"github.com/gorilla/sessions"
@@ -13,6 +14,8 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 var (
@@ -21,6 +24,9 @@ var (
 	initRepo = flag.Bool("init", false, "Initialize a new repository")
 	cfgFile  = flag.String("config", "./cfg_spock.json", "Path to the configuration file")
 	reIndex  = flag.Bool("reindex", false, "Reindex the wiki")
+	gollum   = flag.Bool("import-gollum", false, "Convert the Gollum wiki found in the repository and exit")
+	branch   = flag.String("branch", "", "Branch the wiki commits to (default: the branch checked out)")
+	bareRepo = flag.Bool("bare", false, "Create a bare repository when used with -init")
 )
 
 func makeAbs(p string) string {
@@ -31,23 +37,155 @@ func makeAbs(p string) string {
 	return rv
 }
 
+func importGollum(storage *spock.GitStorage) {
+	sig := &spock.CommitSignature{
+		Name:  "Spock",
+		Email: "spock@wiki.int",
+		When:  time.Now(),
+	}
+	report, err := spock.ImportGollum(storage, sig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for oldName, newName := range report.Renamed {
+		fmt.Printf("renamed: %s -> %s\n", oldName, newName)
+	}
+	for _, name := range report.Converted {
+		fmt.Printf("converted: %s\n", name)
+	}
+	for _, problem := range report.Problems {
+		fmt.Printf("not translated: %s\n", problem)
+	}
+	if report.RevID != "" {
+		fmt.Printf("Gollum wiki imported in commit %s\n", report.RevID)
+	} else {
+		fmt.Printf("Nothing to import\n")
+	}
+}
+
+// checkWiki runs the "check" command, returning the exit status.
+func checkWiki(storage spock.Storage, index *spock.Index, args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	fix := flags.Bool("fix", false, "Repair the search index")
+	flags.Parse(args)
+
+	report, err := spock.CheckWiki(storage, index)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	report.Print(os.Stdout)
+
+	if *fix && report.NeedsFix() {
+		if err = report.FixIndex(storage, index); err != nil {
+			log.Println(err)
+			return 1
+		}
+		fmt.Printf("Index fixed\n")
+	}
+
+	if report.IsClean() {
+		return 0
+	}
+	return 1
+}
+
+// hashPassword runs the "hash-password" command, printing the hash of the
+// password read from stdin; it returns the exit status.
+func hashPassword() int {
+	fmt.Fprintf(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		log.Println(err)
+		return 1
+	}
+	hash, err := spock.HashPassword(strings.TrimRight(password, "\r\n"))
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	fmt.Println(hash)
+	return 0
+}
+
 func main() {
 	flag.Parse()
 
+	if flag.Arg(0) == "hash-password" {
+		os.Exit(hashPassword())
+	}
+
 	if len(*repoDir) == 0 {
-		fmt.Printf("ERROR: You must specify a repository with -repo\nUsage:\n")
+		fmt.Printf("ERROR: You must specify a repository with -repo\nUsage: spock [options] [check [-fix]]\n       spock hash-password\n")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 
-	storage, err := spock.OpenGitStorage(makeAbs(*repoDir), *initRepo)
+	var storage *spock.GitStorage
+	var err error
+	if *initRepo && *bareRepo {
+		storage, err = spock.CreateBareGitStorage(makeAbs(*repoDir))
+	} else {
+		storage, err = spock.OpenGitStorage(makeAbs(*repoDir), *initRepo)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer storage.Close()
+
+	if *branch != "" {
+		if err = storage.SetBranch(*branch); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	index, err := spock.OpenIndex(makeAbs(*repoDir))
+	if *gollum {
+		importGollum(storage)
+		return
+	}
+
+	cfg, err := spock.NewConfiguration(*cfgFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	spock.PreferPandoc = cfg.PreferPandoc
+	spock.Highlight = cfg.Highlight
+	spock.ConfigureDiagrams(cfg.Diagrams)
+	spock.ConfigureSanitizer(cfg.Sanitize)
+
+	// the repositories mounted inside the wiki
+	gitStorages := []*spock.GitStorage{storage}
+	var wiki spock.Storage = storage
+	if len(cfg.Mounts) > 0 {
+		mounts := spock.NewMountStorage(storage)
+		for _, m := range cfg.Mounts {
+			mounted, err := spock.OpenGitStorage(makeAbs(m.Repository), false)
+			if err != nil {
+				log.Fatalf("Cannot open the repository mounted on %s: %s\n", m.Prefix, err)
+			}
+			defer mounted.Close()
+			if err = mounts.Mount(m.Prefix, mounted); err != nil {
+				log.Fatal(err)
+			}
+			gitStorages = append(gitStorages, mounted)
+		}
+		wiki = mounts
+	}
+
+	if cfg.Signing.KeyFile != "" || cfg.Signing.TrustedKeysFile != "" {
+		signer, err := spock.NewCommitSigner(cfg.Signing.KeyFile, cfg.Signing.Passphrase, cfg.Signing.TrustedKeysFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, gs := range gitStorages {
+			gs.SetCommitSigner(signer)
+		}
+	}
+
+	index, rebuilt, err := spock.OpenIndex(makeAbs(*repoDir), cfg.SearchLanguages)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -55,15 +193,30 @@ func main() {
 	// e.g. log.Fatal() skip defers...
 	defer index.Close()
 
-	// If we are opening an existing repository and the index is empty we
-	// run an initial indexing of the whole repository content.
-	if count, err := index.DocCount(); err != nil {
+	if flag.Arg(0) == "check" {
+		// a rebuilt index is empty and would make every page look stale.
+		if rebuilt {
+			if err = index.IndexWiki(wiki); err != nil {
+				index.Close()
+				log.Fatal(err)
+			}
+		}
+		rv := checkWiki(wiki, index, flag.Args()[1:])
+		index.Close()
+		os.Exit(rv)
+	}
+
+	// If we are opening an existing repository and the index is empty, or
+	// was rebuilt, we run an initial indexing of the whole repository
+	// content.
+	count, err := index.DocCount()
+	if err != nil {
 		log.Printf("Error counting documents: %s\n", err)
-	} else if (count == 0 && !*initRepo) || *reIndex {
+	}
+	if (err == nil && count == 0 && !*initRepo) || rebuilt || *reIndex {
 		go func() {
 			log.Printf("New index: Indexing all pages\n")
-			err = index.IndexWiki(storage)
-			if err != nil {
+			if err := index.IndexWiki(wiki); err != nil {
 				log.Printf("Error running the initial indexing: %s\n", err)
 				log.Printf("You can ignore this error if using a new repository\n")
 			} else {
@@ -72,20 +225,45 @@ func main() {
 		}()
 	}
 
-	cfg, err := spock.NewConfiguration(*cfgFile)
+	if !cfg.Validate() {
+		log.Fatal("Invalid configuration file: check 'secret_key' value!")
+	}
+
+	validators, err := spock.NewValidators(&cfg.Validation)
 	if err != nil {
 		log.Fatal(err)
 	}
-	if !cfg.Validate() {
-		log.Fatal("Invalid configuration file: check 'secret_key' value!")
+
+	var webhooks *spock.Webhooks
+	if len(cfg.Webhooks) > 0 {
+		webhooks = spock.NewWebhooks(cfg.Webhooks)
+	}
+
+	var crypter *spock.PageCrypter
+	if cfg.Encryption.KeyFile != "" || cfg.Encryption.Passphrase != "" {
+		if crypter, err = spock.NewPageCrypter(cfg.Encryption.KeyFile, cfg.Encryption.Passphrase); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var renderCache *spock.RenderCache
+	if cfg.RenderCacheSize == 0 {
+		renderCache = spock.NewRenderCache(spock.DefaultRenderCacheSize)
+	} else if cfg.RenderCacheSize > 0 {
+		renderCache = spock.NewRenderCache(cfg.RenderCacheSize)
 	}
 
 	// setup application context
 	appCtx := &spock.AppContext{
+		Config:       cfg,
 		SessionStore: sessions.NewCookieStore([]byte(cfg.SecretKey)),
 		XsrfSecret:   cfg.SecretKey,
-		Storage:      storage,
+		Storage:      wiki,
 		Index:        *index,
+		Validators:   validators,
+		Webhooks:     webhooks,
+		RenderCache:  renderCache,
+		Crypter:      crypter,
 	}
 
 	csig := make(chan os.Signal, 1)
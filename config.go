@@ -6,12 +6,189 @@ package spock
 
 import (
 	"encoding/json"
+	"golang.org/x/crypto/bcrypt"
 	"io/ioutil"
 	"os"
 )
 
 type Configuration struct {
 	SecretKey string `json:"secret_key"`
+
+	// Email addresses of the users allowed to see the administration pages;
+	// they must have an account.
+	Admins []string `json:"admins"`
+
+	// Users logging in with a password; only their identity is verified,
+	// so only they can be administrators or read the encrypted pages.
+	Accounts []AccountConfig `json:"accounts"`
+
+	Validation ValidationConfig `json:"validation"`
+
+	Webhooks []WebhookConfig `json:"webhooks"`
+
+	Signing SigningConfig `json:"signing"`
+
+	// Number of rendered pages kept in memory; a negative value disables
+	// the cache.
+	RenderCacheSize int `json:"render_cache_size"`
+
+	// Repositories mounted inside the wiki.
+	Mounts []MountConfig `json:"mounts"`
+
+	Encryption EncryptionConfig `json:"encryption"`
+
+	// Render the reStructuredText and Org pages with pandoc instead of the
+	// built-in renderers.
+	PreferPandoc bool `json:"prefer_pandoc"`
+
+	Highlight HighlightConfig `json:"highlight"`
+
+	Sanitize SanitizeConfig `json:"sanitize"`
+
+	// The bleve analyzer of the text of the pages, by page language; the
+	// search index is rebuilt when the languages change.
+	SearchLanguages map[string]string `json:"search_languages"`
+
+	// Commands rendering the diagram code blocks, by language.
+	Diagrams map[string]DiagramConfig `json:"diagrams"`
+
+	// Name of the page template used for the new pages of a directory
+	// and its subdirectories, by directory.
+	DefaultTemplates map[string]string `json:"default_templates"`
+}
+
+// HighlightConfig configures the syntax highlighting of the code blocks.
+type HighlightConfig struct {
+	Disabled bool `json:"disabled"`
+	// Name of the color scheme, e.g. "github" or "monokai".
+	Style       string `json:"style"`
+	LineNumbers bool   `json:"line_numbers"`
+}
+
+// SanitizeConfig configures the filtering of the HTML of the pages.
+type SanitizeConfig struct {
+	Disabled bool `json:"disabled"`
+	// Elements and attributes allowed besides the default ones.
+	AllowElements   []string `json:"allow_elements"`
+	AllowAttributes []string `json:"allow_attributes"`
+	// Directories whose pages, and the pages of their subdirectories, are
+	// shown without filtering.
+	TrustedDirectories []string `json:"trusted_directories"`
+}
+
+// DiagramConfig configures the command rendering the code blocks written in
+// a diagram language.
+type DiagramConfig struct {
+	// Command line reading the diagram on stdin and writing an SVG image
+	// on stdout; an empty command disables the language.
+	Command []string `json:"command"`
+	// Variables added to the environment of the command, as "NAME=value".
+	Env []string `json:"env"`
+	// Timeout in seconds.
+	Timeout int `json:"timeout"`
+}
+
+// AccountConfig is a user logging in with a password.
+type AccountConfig struct {
+	Email string `json:"email"`
+	// bcrypt hash of the password, see HashPassword.
+	PasswordHash string `json:"password_hash"`
+}
+
+// EncryptionConfig configures the encryption of the pages with the
+// "encrypted" header flag.
+type EncryptionConfig struct {
+	// Armored private key used to encrypt the pages; when empty the pages
+	// are encrypted with the passphrase.
+	KeyFile    string `json:"key_file"`
+	Passphrase string `json:"passphrase"`
+	// Email addresses of the users, besides the administrators, allowed
+	// to read and edit the encrypted pages.
+	Users []string `json:"users"`
+}
+
+// MountConfig mounts the repository found in the directory Repository
+// under the wiki path Prefix.
+type MountConfig struct {
+	Prefix     string `json:"prefix"`
+	Repository string `json:"repository"`
+}
+
+// SigningConfig configures the OpenPGP signatures of the commits.
+type SigningConfig struct {
+	// Armored private key used to sign the commits.
+	KeyFile    string `json:"key_file"`
+	Passphrase string `json:"passphrase"`
+	// Armored public keys trusted when verifying signatures.
+	TrustedKeysFile string `json:"trusted_keys_file"`
+}
+
+// ValidationConfig configures the checks run on a page before saving it.
+type ValidationConfig struct {
+	// The page header must contain a title.
+	RequireTitle bool `json:"require_title"`
+	// The languages allowed in the page header.
+	Languages []string `json:"languages"`
+	// Maximum size of a page, in bytes.
+	MaxSize int `json:"max_size"`
+	// Regular expressions that must not match the page content.
+	ForbiddenPatterns []string `json:"forbidden_patterns"`
+	// Commands receiving the page on stdin; a non zero exit status rejects
+	// the page.
+	LintCommands [][]string `json:"lint_commands"`
+}
+
+// HashPassword returns the hash of password stored in the configuration of
+// an account.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// HasAccount returns true if email belongs to a user logging in with a
+// password.
+func (cfg *Configuration) HasAccount(email string) bool {
+	for _, account := range cfg.Accounts {
+		if account.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPassword returns true if password is the password of the account of
+// email.
+func (cfg *Configuration) CheckPassword(email, password string) bool {
+	for _, account := range cfg.Accounts {
+		if account.Email == email {
+			return bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)) == nil
+		}
+	}
+	return false
+}
+
+// IsAdmin returns true if email belongs to an administrator.
+func (cfg *Configuration) IsAdmin(email string) bool {
+	for _, admin := range cfg.Admins {
+		if admin == email {
+			return true
+		}
+	}
+	return false
+}
+
+// CanDecrypt returns true if email belongs to a user allowed to read the
+// encrypted pages.
+func (cfg *Configuration) CanDecrypt(email string) bool {
+	if cfg.IsAdmin(email) {
+		return true
+	}
+	for _, user := range cfg.Encryption.Users {
+		if user == email {
+			return true
+		}
+	}
+	return false
 }
 
 func NewConfiguration(filename string) (*Configuration, error) {
@@ -0,0 +1,154 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// OpenPGP encryption of the pages with the "encrypted" header flag.
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"io"
+	"io/ioutil"
+)
+
+const pgpMessageType = "PGP MESSAGE"
+
+// PageCrypter encrypts the body of the pages, either with a passphrase or
+// with an OpenPGP key.
+type PageCrypter struct {
+	passphrase []byte
+	entity     *openpgp.Entity
+}
+
+// NewPageCrypter creates a PageCrypter; when keyFile is empty the pages
+// are encrypted with the passphrase, otherwise they are encrypted for the
+// armored private key found in keyFile, unlocked with the passphrase.
+func NewPageCrypter(keyFile, passphrase string) (*PageCrypter, error) {
+	if keyFile == "" {
+		if passphrase == "" {
+			return nil, errors.New("page encryption needs a passphrase or a key")
+		}
+		return &PageCrypter{passphrase: []byte(passphrase)}, nil
+	}
+
+	entities, err := readKeyRing(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, entity := range entities {
+		if entity.PrivateKey != nil {
+			if err = decryptEntity(entity, passphrase); err != nil {
+				return nil, err
+			}
+			return &PageCrypter{entity: entity}, nil
+		}
+	}
+	return nil, errors.New("no private key found in " + keyFile)
+}
+
+// Encrypt returns the armored OpenPGP message containing plaintext.
+func (pc *PageCrypter) Encrypt(plaintext []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	aw, err := armor.Encode(&buf, pgpMessageType, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var w io.WriteCloser
+	if pc.entity != nil {
+		w, err = openpgp.Encrypt(aw, []*openpgp.Entity{pc.entity}, nil, nil, nil)
+	} else {
+		w, err = openpgp.SymmetricallyEncrypt(aw, pc.passphrase, nil, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	if err = aw.Close(); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// Decrypt returns the content of an armored OpenPGP message.
+func (pc *PageCrypter) Decrypt(ciphertext []byte) ([]byte, error) {
+	block, err := armor.Decode(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	if block.Type != pgpMessageType {
+		return nil, errors.New("the page body is not an OpenPGP message")
+	}
+
+	var keyring openpgp.EntityList
+	if pc.entity != nil {
+		keyring = append(keyring, pc.entity)
+	}
+	tried := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		// the prompt is called again when the passphrase is wrong.
+		if !symmetric || tried || pc.passphrase == nil {
+			return nil, errors.New("cannot decrypt the page")
+		}
+		tried = true
+		return pc.passphrase, nil
+	}
+
+	md, err := openpgp.ReadMessage(block.Body, keyring, prompt, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(md.UnverifiedBody)
+}
+
+// EncryptPage encrypts the body of a page with the "encrypted" header
+// flag, leaving the header in plain text.
+func (pc *PageCrypter) EncryptPage(page *Page) error {
+	if !page.Header.Encrypted {
+		return nil
+	}
+
+	ciphertext, err := pc.Encrypt(page.Content)
+	if err != nil {
+		return err
+	}
+	header := page.RawBytes[:len(page.RawBytes)-len(page.Content)]
+
+	var raw []byte
+	raw = append(raw, header...)
+	raw = append(raw, '\n')
+	raw = append(raw, ciphertext...)
+	return page.SetRawBytes(raw)
+}
+
+// DecryptPage replaces the encrypted body of a page with the plain text,
+// the reverse of EncryptPage.
+func (pc *PageCrypter) DecryptPage(page *Page) error {
+	if !page.Header.Encrypted {
+		return nil
+	}
+
+	plaintext, err := pc.Decrypt(page.Content)
+	if err != nil {
+		return err
+	}
+	header := page.RawBytes[:len(page.RawBytes)-len(page.Content)]
+
+	var raw []byte
+	raw = append(raw, header...)
+	raw = append(raw, plaintext...)
+	mtime := page.Mtime
+	err = page.SetRawBytes(raw)
+	page.Mtime = mtime
+	return err
+}
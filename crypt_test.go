@@ -0,0 +1,79 @@
+package spock
+
+import (
+	"bytes"
+	"crypto"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"testing"
+)
+
+var testEncryptedPage = `---
+title: "Credentials"
+encrypted: true
+---
+# Database
+
+The password is hunter2.
+`
+
+func testPageCrypt(t *testing.T, pc *PageCrypter) {
+	page := NewPage("credentials.md")
+	checkFatal(t, page.SetRawBytes([]byte(testEncryptedPage)))
+	if !page.Header.Encrypted {
+		t.Fatal("The page should have the encrypted flag")
+	}
+
+	checkFatal(t, pc.EncryptPage(page))
+	if bytes.Contains(page.RawBytes, []byte("hunter2")) {
+		t.Fatal("The encrypted page contains the plain text")
+	}
+	if page.Header.Title != "Credentials" || !page.Header.Encrypted {
+		t.Fatalf("The header should be left in plain text: %+v", page.Header)
+	}
+
+	wikiPage, err := page.ToWikiPage(nil)
+	checkFatal(t, err)
+	if wikiPage.Body != "" {
+		t.Fatal("The body of an encrypted page must not be indexed")
+	}
+
+	checkFatal(t, pc.DecryptPage(page))
+	if string(page.RawBytes) != testEncryptedPage {
+		t.Fatalf("The decrypted page should be:\n%s\nis:\n%s", testEncryptedPage, page.RawBytes)
+	}
+}
+
+func TestPassphraseEncryption(t *testing.T) {
+	pc, err := NewPageCrypter("", "correct horse battery staple")
+	checkFatal(t, err)
+	testPageCrypt(t, pc)
+
+	ciphertext, err := pc.Encrypt([]byte("secret"))
+	checkFatal(t, err)
+	wrong, err := NewPageCrypter("", "wrong passphrase")
+	checkFatal(t, err)
+	if _, err = wrong.Decrypt(ciphertext); err == nil {
+		t.Fatal("Decrypting with the wrong passphrase should fail")
+	}
+}
+
+func TestKeyEncryption(t *testing.T) {
+	// without a preferred hash OpenPGP falls back to RIPEMD160
+	config := &packet.Config{DefaultHash: crypto.SHA256}
+	entity, err := openpgp.NewEntity("Spock Wiki", "", "wiki@example.com", config)
+	checkFatal(t, err)
+	testPageCrypt(t, &PageCrypter{entity: entity})
+}
+
+func TestUnencryptedPage(t *testing.T) {
+	pc, err := NewPageCrypter("", "passphrase")
+	checkFatal(t, err)
+
+	page := NewPage("index.md")
+	checkFatal(t, page.SetRawBytes([]byte(testPageContent)))
+	checkFatal(t, pc.EncryptPage(page))
+	if string(page.RawBytes) != testPageContent {
+		t.Fatal("A page without the encrypted flag must not be modified")
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// Diagrams: the code blocks written in a diagram language, like dot, are
+// replaced by the SVG image made by a local executable.
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultDiagramTimeout is the time a diagram tool can run before being
+// killed, when the configuration doesn't specify it.
+const DefaultDiagramTimeout = 10 * time.Second
+
+// DiagramCacheSize is the number of rendered diagrams kept in memory.
+const DiagramCacheSize = 200
+
+// DiagramTool is a command reading the source of a diagram on stdin and
+// writing an SVG image on stdout. The source is written by the editors of
+// the wiki, so the tool must not be able to read files or make network
+// requests on their behalf.
+type DiagramTool struct {
+	Command []string
+	// Variables added to the environment of the command.
+	Env     []string
+	Timeout time.Duration
+}
+
+// DiagramTools are the diagram tools, by language; the languages whose tool
+// is not installed are shown as code blocks.
+var DiagramTools = map[string]DiagramTool{
+	"dot": {Command: []string{"dot", "-Tsvg"}},
+	// the SANDBOX profile forbids !include of files and URLs, and the
+	// other directives reading files or the environment
+	"plantuml": {
+		Command: []string{"plantuml", "-DPLANTUML_SECURITY_PROFILE=SANDBOX", "-tsvg", "-pipe"},
+		Env:     []string{"PLANTUML_SECURITY_PROFILE=SANDBOX"},
+	},
+	"mermaid": {Command: []string{"mmdc", "--input", "-", "--output", "-", "--outputFormat", "svg", "--quiet"}},
+}
+
+// ConfigureDiagrams replaces the default diagram tools with the ones found
+// in the configuration; a tool with an empty command is disabled.
+func ConfigureDiagrams(configs map[string]DiagramConfig) {
+	for lang, cfg := range configs {
+		if len(cfg.Command) == 0 {
+			delete(DiagramTools, lang)
+			continue
+		}
+		DiagramTools[lang] = DiagramTool{
+			Command: cfg.Command,
+			Env:     cfg.Env,
+			Timeout: time.Duration(cfg.Timeout) * time.Second,
+		}
+	}
+}
+
+// diagrams keeps the most recently rendered diagrams, keyed by a hash of
+// their source and of the tool.
+var diagrams = newLRUCache(DiagramCacheSize)
+
+// renderDiagrams replaces the code blocks of a rendered page written in a
+// diagram language with their SVG image.
+func renderDiagrams(content []byte) []byte {
+	if !bytes.Contains(content, []byte("<pre><code class=")) {
+		return content
+	}
+
+	return codeBlockRe.ReplaceAllFunc(content, func(block []byte) []byte {
+		m := codeBlockRe.FindSubmatch(block)
+		lang := html.UnescapeString(string(m[1]))
+		tool, ok := DiagramTools[lang]
+		if !ok {
+			return block
+		}
+		// without the tool the source is shown
+		if _, err := exec.LookPath(tool.Command[0]); err != nil {
+			return block
+		}
+
+		svg, err := tool.render(lang, []byte(html.UnescapeString(string(m[2]))))
+		if err != nil {
+			log.Printf("Error rendering a %s diagram: %s\n", lang, err)
+			warning := fmt.Sprintf(`<p class="alert alert-warning">Cannot render the %s diagram: %s</p>`,
+				html.EscapeString(lang), html.EscapeString(err.Error()))
+			return append([]byte(warning), block...)
+		}
+		return []byte(fmt.Sprintf(`<div class="diagram diagram-%s">%s</div>`, html.EscapeString(lang), svg))
+	})
+}
+
+// render returns the SVG image of the diagram source, taking it from the
+// cache when the same diagram was already rendered.
+func (tool DiagramTool) render(lang string, source []byte) ([]byte, error) {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", lang, strings.Join(tool.Command, "\x00"))
+	h.Write(source)
+	key := fmt.Sprintf("%x", h.Sum(nil))
+	if svg, ok := diagrams.get(key); ok {
+		return svg.([]byte), nil
+	}
+
+	timeout := tool.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDiagramTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, tool.Command[0], tool.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(source)
+	if len(tool.Env) > 0 {
+		cmd.Env = append(os.Environ(), tool.Env...)
+	}
+	var out, errout bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errout
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s timed out after %s", tool.Command[0], timeout)
+		}
+		if msg := strings.TrimSpace(errout.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", tool.Command[0], msg)
+		}
+		return nil, fmt.Errorf("%s: %s", tool.Command[0], err)
+	}
+
+	// skip the XML declaration and the doctype
+	svg := out.Bytes()
+	start := bytes.Index(svg, []byte("<svg"))
+	if start == -1 {
+		return nil, errors.New("the output is not an SVG image")
+	}
+	svg = bytes.TrimSpace(svg[start:])
+
+	diagrams.put(key, svg)
+	return svg, nil
+}
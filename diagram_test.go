@@ -0,0 +1,41 @@
+package spock
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderDiagrams(t *testing.T) {
+	defer func(tools map[string]DiagramTool) { DiagramTools = tools }(DiagramTools)
+	DiagramTools = map[string]DiagramTool{
+		"fake":    {Command: []string{"sh", "-c", `printf '<?xml version="1.0"?>\n<svg>'; cat; printf '</svg>\n'`}},
+		"missing": {Command: []string{"no-such-diagram-tool"}},
+		"broken":  {Command: []string{"sh", "-c", "echo syntax error >&2; exit 1"}},
+		"slow":    {Command: []string{"sh", "-c", "exec sleep 5"}, Timeout: 100 * time.Millisecond},
+	}
+
+	page := NewPage("diagrams.md")
+	checkFatal(t, page.SetRawBytes([]byte("```fake\na -> b\n```\n")))
+	html, err := page.Render(nil)
+	checkFatal(t, err)
+	if expected := `<div class="diagram diagram-fake"><svg>a -&gt; b` + "\n</svg></div>"; !strings.Contains(string(html), expected) {
+		t.Errorf("expected %q, got:\n%s", expected, html)
+	}
+	if diagrams.Len() == 0 {
+		t.Error("the diagram should be cached")
+	}
+
+	checks := map[string]string{
+		"missing": `<pre><code class="language-missing">a -&gt; b`,
+		"broken":  "Cannot render the broken diagram: sh: syntax error",
+		"slow":    "Cannot render the slow diagram: sh timed out after 100ms",
+	}
+	for lang, expected := range checks {
+		block := `<pre><code class="language-` + lang + `">a -&gt; b</code></pre>`
+		result := string(renderDiagrams([]byte(block)))
+		if !strings.Contains(result, expected) || !strings.Contains(result, block) {
+			t.Errorf("%s: expected %q and the source, got %q", lang, expected, result)
+		}
+	}
+}
@@ -11,13 +11,86 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
+const branchPrefix = "refs/heads/"
+
+// GitStorage stores the wiki pages in a git repository. It's safe for
+// concurrent use: the methods changing the repository are run one at a
+// time by a single writer goroutine, while the read only methods can run in
+// parallel when no write is in progress.
 type GitStorage struct {
+	// WorkDir is the working directory or, for a bare repository, the
+	// repository itself.
 	WorkDir string
 	r       *git.Repository
+	signer  *CommitSigner
+
+	// in a bare repository the pages are read from the last commit and
+	// the changes are committed without a checkout.
+	bare bool
+
+	// mu is held for writing by the writer goroutine while it runs a job,
+	// and for reading by the read only methods.
+	mu     sync.RWMutex
+	writes chan writeJob
+
+	// the page list of the last commit, as returned by ListPages.
+	pagesMu   sync.Mutex
+	pagesHead string
+	pages     []string
+}
+
+// writeJob is a change to the repository, queued to the writer goroutine.
+type writeJob struct {
+	fn   func() (RevID, error)
+	done chan writeResult
+}
+
+type writeResult struct {
+	revId RevID
+	err   error
+}
+
+func newGitStorage(path string, repo *git.Repository) *GitStorage {
+	gs := &GitStorage{WorkDir: path, r: repo, bare: repo.IsBare(), writes: make(chan writeJob)}
+	go gs.writer()
+	return gs
+}
+
+// writer runs the queued changes, in order; nothing is written while HEAD
+// is detached, since there's no branch to commit to.
+func (gs *GitStorage) writer() {
+	for job := range gs.writes {
+		var revId RevID
+		gs.mu.Lock()
+		_, err := gs.headRef()
+		if err == nil {
+			revId, err = job.fn()
+		}
+		gs.mu.Unlock()
+		job.done <- writeResult{revId, err}
+	}
+}
+
+// write queues a change to the repository and waits for its completion.
+func (gs *GitStorage) write(fn func() (RevID, error)) (RevID, error) {
+	done := make(chan writeResult, 1)
+	gs.writes <- writeJob{fn, done}
+	result := <-done
+	return result.revId, result.err
+}
+
+// Close stops the writer goroutine; the storage must not be used after
+// calling Close.
+func (gs *GitStorage) Close() {
+	close(gs.writes)
 }
 
 // Create a new git repository, initializing it.
@@ -27,14 +100,27 @@ func CreateGitStorage(path string) (*GitStorage, error) {
 		return nil, err
 	}
 
-	gitstorage := &GitStorage{WorkDir: path, r: repo}
-	return gitstorage, nil
+	return newGitStorage(path, repo), nil
 }
 
-// Open an existing git repository, optionally creating a new one if the
-// specified directory is not found and 'create' is true.
+// Create a new bare git repository, initializing it.
+func CreateBareGitStorage(path string) (*GitStorage, error) {
+	repo, err := git.InitRepository(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return newGitStorage(path, repo), nil
+}
+
+// Open an existing git repository, bare or not, optionally creating a new
+// one if the specified directory is not found and 'create' is true.
 func OpenGitStorage(path string, create bool) (*GitStorage, error) {
 	if _, err := os.Stat(filepath.Join(path, ".git")); err != nil {
+		// a bare repository doesn't have a ".git" directory.
+		if repo, err := git.OpenRepository(path); err == nil && repo.IsBare() {
+			return newGitStorage(path, repo), nil
+		}
 		if create {
 			return CreateGitStorage(path)
 		} else {
@@ -45,8 +131,12 @@ func OpenGitStorage(path string, create bool) (*GitStorage, error) {
 	if err != nil {
 		return nil, err
 	}
-	gitstorage := &GitStorage{WorkDir: path, r: repo}
-	return gitstorage, nil
+	return newGitStorage(path, repo), nil
+}
+
+// IsBare returns true if the repository doesn't have a working directory.
+func (gs *GitStorage) IsBare() bool {
+	return gs.bare
 }
 
 func (gs *GitStorage) MakeAbsPath(path string) string {
@@ -65,6 +155,36 @@ func (gs *GitStorage) JoinPath(relpath string) (string, error) {
 	return res, nil
 }
 
+// ReadFile returns the content and the modification time of a file; in a
+// bare repository the file is read from the last commit.
+func (gs *GitStorage) ReadFile(relpath string) ([]byte, time.Time, error) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+
+	if gs.bare {
+		data, err := gs.readHeadFile(cleanTreePath(relpath))
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		commit, _, err := gs.currentState()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return data, commit.Committer().When, nil
+	}
+
+	filename, err := gs.JoinPath(relpath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := ioutil.ReadFile(filename)
+	return data, fi.ModTime(), err
+}
+
 // Returns the last (root) commit and tree objects.
 func (gs *GitStorage) currentState() (commit *git.Commit, tree *git.Tree, err error) {
 	var head *git.Reference
@@ -81,25 +201,97 @@ func (gs *GitStorage) currentState() (commit *git.Commit, tree *git.Tree, err er
 	return
 }
 
+// headRef returns the name of the branch HEAD points to (e.g.
+// "refs/heads/main"), which might not exist yet on a new repository.
+func (gs *GitStorage) headRef() (string, error) {
+	head, err := gs.r.References.Lookup("HEAD")
+	if err != nil {
+		return "", err
+	}
+	refname := head.SymbolicTarget()
+	if refname == "" {
+		return "", errors.New("HEAD is detached")
+	}
+	return refname, nil
+}
+
 // Returns true if the git repository has a "root commit" (i.e. the so called
-// initial commit).
-func (gs *GitStorage) hasRootCommit() bool {
-	refname := "refs/heads/master"
-	_, err := gs.r.References.Lookup(refname)
+// initial commit). A detached HEAD is an error and not an empty repository:
+// the wiki wouldn't know which branch to read and to commit to.
+func (gs *GitStorage) hasRootCommit() (bool, error) {
+	refname, err := gs.headRef()
 	if err != nil {
-		return false
+		return false, err
+	}
+	_, err = gs.r.References.Lookup(refname)
+	if git.IsErrorCode(err, git.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
 	}
 
-	return true
+	return true, nil
 }
 
-func (gs *GitStorage) saveIndex(idx *git.Index, signature *CommitSignature, message string) (*git.Oid, error) {
-	sig := &git.Signature{
-		Name:  signature.Name,
-		Email: signature.Email,
-		When:  signature.When,
+// CurrentBranch returns the name of the branch the wiki commits to, that
+// is the branch HEAD points to.
+func (gs *GitStorage) CurrentBranch() (string, error) {
+	refname, err := gs.headRef()
+	if err != nil {
+		return "", err
 	}
+	return strings.TrimPrefix(refname, branchPrefix), nil
+}
 
+// SetBranch makes the wiki commit to branch; on a repository without
+// commits HEAD is moved to the new branch, otherwise branch must be the one
+// checked out.
+func (gs *GitStorage) SetBranch(branch string) error {
+	_, err := gs.write(func() (RevID, error) {
+		current, err := gs.headRef()
+		if err != nil {
+			return "", err
+		}
+		if current == branchPrefix+branch {
+			return "", nil
+		}
+		if hasRoot, err := gs.hasRootCommit(); err != nil {
+			return "", err
+		} else if hasRoot {
+			return "", fmt.Errorf("the repository is on branch %s, not %s", strings.TrimPrefix(current, branchPrefix), branch)
+		}
+		_, err = gs.r.References.CreateSymbolic("HEAD", branchPrefix+branch, true, "spock: use branch "+branch)
+		return "", err
+	})
+	return err
+}
+
+// Branches returns the names of the local branches.
+func (gs *GitStorage) Branches() ([]string, error) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+
+	iter, err := gs.r.NewReferenceIteratorGlob(branchPrefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Free()
+
+	var result []string
+	for {
+		ref, err := iter.Next()
+		if git.IsErrorCode(err, git.ErrIterOver) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		result = append(result, strings.TrimPrefix(ref.Name(), branchPrefix))
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (gs *GitStorage) saveIndex(idx *git.Index, signature *CommitSignature, message string) (*git.Oid, error) {
 	treeId, err := idx.WriteTree()
 	if err != nil {
 		return nil, err
@@ -115,24 +307,291 @@ func (gs *GitStorage) saveIndex(idx *git.Index, signature *CommitSignature, mess
 		return nil, err
 	}
 
-	var commitId *git.Oid
-	if gs.hasRootCommit() {
-		var currentTip *git.Commit
+	return gs.createCommit(tree, signature, message)
+}
+
+// createCommit commits tree on top of the current branch.
+func (gs *GitStorage) createCommit(tree *git.Tree, signature *CommitSignature, message string) (*git.Oid, error) {
+	sig := &git.Signature{
+		Name:  signature.Name,
+		Email: signature.Email,
+		When:  signature.When,
+	}
 
-		currentTip, _, err = gs.currentState()
+	var parents []*git.Commit
+	hasRoot, err := gs.hasRootCommit()
+	if err != nil {
+		return nil, err
+	}
+	if hasRoot {
+		currentTip, _, err := gs.currentState()
 		if err != nil {
 			return nil, err
 		}
+		parents = append(parents, currentTip)
+	}
+
+	if gs.signer != nil && gs.signer.CanSign() {
+		return gs.createSignedCommit(sig, message, tree, parents...)
+	}
+	return gs.r.CreateCommit("HEAD", sig, sig, message, tree, parents...)
+}
 
-		commitId, err = gs.r.CreateCommit("HEAD", sig, sig, message, tree, currentTip)
+// createSignedCommit writes a commit object carrying an OpenPGP signature
+// and moves the current branch to it; libgit2 can't sign commits, so the
+// commit object is built by hand.
+func (gs *GitStorage) createSignedCommit(sig *git.Signature, message string, tree *git.Tree, parents ...*git.Commit) (*git.Oid, error) {
+	var parentIds []*git.Oid
+	for _, parent := range parents {
+		parentIds = append(parentIds, parent.Id())
+	}
+
+	payload := buildCommitObject(tree.Id(), parentIds, sig, sig, message)
+	signature, err := gs.signer.Sign(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	odb, err := gs.r.Odb()
+	if err != nil {
+		return nil, err
+	}
+	commitId, err := odb.Write(addCommitSignature(payload, signature), git.ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	refname, err := gs.headRef()
+	if err != nil {
+		return nil, err
+	}
+	_, err = gs.r.References.Create(refname, commitId, true, "commit: "+message)
+	if err != nil {
+		return nil, err
+	}
+
+	return commitId, nil
+}
+
+// fileChange is a change to a single file committed without a working
+// directory; remove deletes the file instead of writing data.
+type fileChange struct {
+	path   string
+	data   []byte
+	remove bool
+}
+
+// commitChanges builds a new tree from the last commit and the changes,
+// and commits it; it's how a bare repository is modified.
+func (gs *GitStorage) commitChanges(changes []fileChange, signature *CommitSignature, message string) (revId RevID, err error) {
+	var tree *git.Tree
+	hasRoot, err := gs.hasRootCommit()
+	if err != nil {
+		return
+	}
+	if hasRoot {
+		if _, tree, err = gs.currentState(); err != nil {
+			return
+		}
+	}
+
+	for _, change := range changes {
+		change.path = cleanTreePath(change.path)
+		if change.path == "" {
+			err = errors.New("Invalid empty path")
+			return
+		}
+
+		var blobId *git.Oid
+		if !change.remove {
+			if blobId, err = gs.r.CreateBlobFromBuffer(change.data); err != nil {
+				return
+			}
+		}
+		var treeId *git.Oid
+		if treeId, err = gs.updateTree(tree, change.path, blobId); err != nil {
+			return
+		}
+		if treeId == nil {
+			tree = nil
+		} else if tree, err = gs.r.LookupTree(treeId); err != nil {
+			return
+		}
+	}
+
+	// removing the last page leaves an empty tree.
+	if tree == nil {
+		if tree, err = gs.emptyTree(); err != nil {
+			return
+		}
+	}
+
+	commitId, err := gs.createCommit(tree, signature, message)
+	if err != nil {
+		return
+	}
+	revId = RevID(commitId.String())
+	return
+}
+
+func (gs *GitStorage) emptyTree() (*git.Tree, error) {
+	tb, err := gs.r.TreeBuilder()
+	if err != nil {
+		return nil, err
+	}
+	defer tb.Free()
+
+	treeId, err := tb.Write()
+	if err != nil {
+		return nil, err
+	}
+	return gs.r.LookupTree(treeId)
+}
+
+// updateTree writes a copy of tree (nil for a missing directory) where the
+// file "filepath" points to the blob id, or is removed when id is nil;
+// missing directories are created and the directories left empty are
+// removed, in which case the returned id is nil.
+func (gs *GitStorage) updateTree(tree *git.Tree, filepath string, id *git.Oid) (*git.Oid, error) {
+	var tb *git.TreeBuilder
+	var err error
+	if tree != nil {
+		tb, err = gs.r.TreeBuilderFromTree(tree)
 	} else {
-		commitId, err = gs.r.CreateCommit("HEAD", sig, sig, message, tree)
+		tb, err = gs.r.TreeBuilder()
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer tb.Free()
+
+	name, rest := filepath, ""
+	if i := strings.Index(filepath, "/"); i != -1 {
+		name, rest = filepath[:i], filepath[i+1:]
+	}
+
+	var entry *git.TreeEntry
+	if tree != nil {
+		entry = tree.EntryByName(name)
+	}
+
+	if rest == "" {
+		if id == nil {
+			if entry == nil {
+				return nil, fmt.Errorf("%s: file not found", name)
+			}
+			err = tb.Remove(name)
+		} else {
+			err = tb.Insert(name, id, git.FilemodeBlob)
+		}
+	} else {
+		var subtree *git.Tree
+		if entry != nil {
+			if entry.Type != git.ObjectTree {
+				return nil, fmt.Errorf("%s is not a directory", name)
+			}
+			if subtree, err = gs.r.LookupTree(entry.Id); err != nil {
+				return nil, err
+			}
+		} else if id == nil {
+			return nil, fmt.Errorf("%s: directory not found", name)
+		}
+
+		var subtreeId *git.Oid
+		if subtreeId, err = gs.updateTree(subtree, rest, id); err != nil {
+			return nil, err
+		}
+		if subtreeId == nil {
+			err = tb.Remove(name)
+		} else {
+			err = tb.Insert(name, subtreeId, git.FilemodeTree)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	treeId, err := tb.Write()
+	if err != nil {
+		return nil, err
+	}
+	newTree, err := gs.r.LookupTree(treeId)
+	if err != nil {
+		return nil, err
+	}
+	if newTree.EntryCount() == 0 {
+		return nil, nil
+	}
+	return treeId, nil
+}
+
+// readHeadFile returns the content of a file in the last commit.
+func (gs *GitStorage) readHeadFile(filename string) ([]byte, error) {
+	if hasRoot, err := gs.hasRootCommit(); err != nil {
+		return nil, err
+	} else if !hasRoot {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+	}
+	_, tree, err := gs.currentState()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := tree.EntryByPath(filename)
+	if err != nil || entry.Type != git.ObjectBlob {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+	}
+	blob, err := gs.r.LookupBlob(entry.Id)
+	if err != nil {
+		return nil, err
+	}
+	return blob.Contents(), nil
+}
+
+// SetCommitSigner enables signing of the new commits and verification of
+// the signatures shown in the page logs.
+func (gs *GitStorage) SetCommitSigner(signer *CommitSigner) {
+	gs.signer = signer
+}
+
+// checkSignature fills the signature informations of a CommitLog.
+func (gs *GitStorage) checkSignature(cl *CommitLog) {
+	oid, err := git.NewOid(cl.Id)
+	if err != nil {
+		return
+	}
+	odb, err := gs.r.Odb()
+	if err != nil {
+		return
+	}
+	obj, err := odb.Read(oid)
+	if err != nil {
+		log.Printf("Cannot read commit %s: %s\n", cl.Id, err)
+		return
 	}
 
-	return commitId, err
+	payload, signature, signed := splitCommitSignature(obj.Data())
+	cl.Signed = signed
+	if !signed || gs.signer == nil {
+		return
+	}
+	if signer, err := gs.signer.Verify(payload, signature); err == nil {
+		cl.SignatureValid = true
+		cl.SignedBy = signer
+	}
 }
 
-func (gs *GitStorage) CommitFile(path string, signature *CommitSignature, message string) (revId RevID, err error) {
+func (gs *GitStorage) CommitFile(path string, signature *CommitSignature, message string) (RevID, error) {
+	return gs.write(func() (RevID, error) {
+		return gs.commitFile(path, signature, message)
+	})
+}
+
+func (gs *GitStorage) commitFile(path string, signature *CommitSignature, message string) (revId RevID, err error) {
+	if gs.bare {
+		err = errors.New("Cannot commit a file from the working directory of a bare repository")
+		return
+	}
+
 	idx, err := gs.r.Index()
 	if err != nil {
 		return
@@ -151,7 +610,25 @@ func (gs *GitStorage) CommitFile(path string, signature *CommitSignature, messag
 	return
 }
 
-func (gs *GitStorage) RenamePage(origPath, destPath string, signature *CommitSignature, message string) (revId RevID, err error) {
+func (gs *GitStorage) RenamePage(origPath, destPath string, signature *CommitSignature, message string) (RevID, error) {
+	return gs.write(func() (RevID, error) {
+		return gs.renamePage(origPath, destPath, signature, message)
+	})
+}
+
+func (gs *GitStorage) renamePage(origPath, destPath string, signature *CommitSignature, message string) (revId RevID, err error) {
+	if gs.bare {
+		var data []byte
+		if data, err = gs.readHeadFile(origPath); err != nil {
+			return
+		}
+		changes := []fileChange{
+			{path: destPath, data: data},
+			{path: origPath, remove: true},
+		}
+		return gs.commitChanges(changes, signature, message)
+	}
+
 	idx, err := gs.r.Index()
 	if err != nil {
 		return
@@ -181,7 +658,17 @@ func (gs *GitStorage) RenamePage(origPath, destPath string, signature *CommitSig
 	return
 }
 
-func (gs *GitStorage) DeletePage(path string, signature *CommitSignature, message string) (revId RevID, err error) {
+func (gs *GitStorage) DeletePage(path string, signature *CommitSignature, message string) (RevID, error) {
+	return gs.write(func() (RevID, error) {
+		return gs.deletePage(path, signature, message)
+	})
+}
+
+func (gs *GitStorage) deletePage(path string, signature *CommitSignature, message string) (revId RevID, err error) {
+	if gs.bare {
+		return gs.commitChanges([]fileChange{{path: path, remove: true}}, signature, message)
+	}
+
 	idx, err := gs.r.Index()
 	if err != nil {
 		return
@@ -217,6 +704,9 @@ func extractCommitLog(commit *git.Commit) *CommitLog {
 }
 
 func (gs *GitStorage) LogsForPage(path string) (result []CommitLog, err error) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+
 	var oidList []git.Oid
 	var commitMap = make(map[git.Oid]*git.Commit)
 
@@ -256,12 +746,199 @@ func (gs *GitStorage) LogsForPage(path string) (result []CommitLog, err error) {
 	for _, oid := range oidList {
 		commit := commitMap[oid]
 		cl := extractCommitLog(commit)
+		gs.checkSignature(cl)
 		result = append(result, *cl)
 	}
 
 	return
 }
 
+// DeletedPages walks the history looking for deleted pages; each page is
+// reported once, with the most recent commit that deleted it. Pages that
+// exist in the last commit and files that were renamed are skipped.
+func (gs *GitStorage) DeletedPages() (result []DeletedPage, err error) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+
+	hasRoot, err := gs.hasRootCommit()
+	if err != nil || !hasRoot {
+		return
+	}
+
+	_, headTree, err := gs.currentState()
+	if err != nil {
+		return
+	}
+
+	diffopts, err := git.DefaultDiffOptions()
+	if err != nil {
+		return
+	}
+
+	walker, err := gs.r.Walk()
+	if err != nil {
+		return
+	}
+	walker.Sorting(git.SortTime)
+	if err = walker.PushHead(); err != nil {
+		return
+	}
+
+	seen := make(map[string]bool)
+	var walkErr error
+	err = walker.Iterate(func(commit *git.Commit) bool {
+		if commit.ParentCount() == 0 {
+			return true
+		}
+
+		var tree, parentTree *git.Tree
+		var diff *git.Diff
+		var numDeltas int
+
+		if tree, walkErr = commit.Tree(); walkErr != nil {
+			return false
+		}
+		if parentTree, walkErr = commit.Parent(0).Tree(); walkErr != nil {
+			return false
+		}
+		if diff, walkErr = gs.r.DiffTreeToTree(parentTree, tree, &diffopts); walkErr != nil {
+			return false
+		}
+		if numDeltas, walkErr = diff.NumDeltas(); walkErr != nil {
+			return false
+		}
+
+		var deleted []git.DiffDelta
+		added := make(map[git.Oid]bool)
+		for i := 0; i < numDeltas; i++ {
+			var delta git.DiffDelta
+			if delta, walkErr = diff.GetDelta(i); walkErr != nil {
+				return false
+			}
+			switch delta.Status {
+			case git.DeltaAdded:
+				added[*delta.NewFile.Oid] = true
+			case git.DeltaDeleted:
+				deleted = append(deleted, delta)
+			}
+		}
+
+		for _, delta := range deleted {
+			path := delta.OldFile.Path
+			if seen[path] || !IsPageFilename(path) {
+				continue
+			}
+			seen[path] = true
+
+			// renamed in the same commit
+			if added[*delta.OldFile.Oid] {
+				continue
+			}
+			// recreated later
+			if _, err := headTree.EntryByPath(path); err == nil {
+				continue
+			}
+			result = append(result, DeletedPage{Path: path, Commit: *extractCommitLog(commit)})
+		}
+
+		return true
+	})
+	if walkErr != nil {
+		err = walkErr
+	}
+
+	return
+}
+
+// RestorePage recreates the page "path", that was deleted by the commit
+// "rev", with the content it had before being deleted.
+func (gs *GitStorage) RestorePage(path, rev string, signature *CommitSignature, message string) (RevID, error) {
+	return gs.write(func() (RevID, error) {
+		return gs.restorePage(path, rev, signature, message)
+	})
+}
+
+func (gs *GitStorage) restorePage(path, rev string, signature *CommitSignature, message string) (revId RevID, err error) {
+	if gs.bare {
+		if _, err = gs.readHeadFile(path); err == nil {
+			err = fmt.Errorf("%s already exists", path)
+			return
+		}
+	} else {
+		var fullpath string
+		if fullpath, err = gs.JoinPath(path); err != nil {
+			return
+		}
+		if _, err = os.Stat(fullpath); err == nil {
+			err = fmt.Errorf("%s already exists", path)
+			return
+		}
+	}
+
+	commit, err := gs.commitFromId(rev)
+	if err != nil {
+		return
+	}
+	if commit.ParentCount() == 0 {
+		err = fmt.Errorf("commit %s has no parent", rev)
+		return
+	}
+	tree, err := commit.Parent(0).Tree()
+	if err != nil {
+		return
+	}
+
+	return gs.commitFileFromTree(tree, path, signature, message)
+}
+
+// RevertPage restores the content that the page "path" had at the
+// revision "rev".
+func (gs *GitStorage) RevertPage(path, rev string, signature *CommitSignature, message string) (RevID, error) {
+	return gs.write(func() (RevID, error) {
+		tree, err := gs.treeFromId(rev)
+		if err != nil {
+			return "", err
+		}
+
+		return gs.commitFileFromTree(tree, path, signature, message)
+	})
+}
+
+// commitFileFromTree copies the page "path" found in tree to the working
+// directory and commits it.
+func (gs *GitStorage) commitFileFromTree(tree *git.Tree, path string, signature *CommitSignature, message string) (revId RevID, err error) {
+	fullpath, err := gs.JoinPath(path)
+	if err != nil {
+		return
+	}
+	if !IsPageFilename(path) {
+		err = fmt.Errorf("%s is not a wiki page", path)
+		return
+	}
+
+	entry, err := tree.EntryByPath(path)
+	if err != nil {
+		return
+	}
+	blob, err := gs.r.LookupBlob(entry.Id)
+	if err != nil {
+		return
+	}
+
+	if gs.bare {
+		return gs.commitChanges([]fileChange{{path: path, data: blob.Contents()}}, signature, message)
+	}
+
+	if err = MkMissingDirs(fullpath); err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(fullpath, blob.Contents(), 0644); err != nil {
+		return
+	}
+
+	return gs.commitFile(path, signature, message)
+}
+
 // LookupPage is used to fetch pages from the wiki storage. The "relpath"
 // argument refers to a page path relative to the root of the wiki; for
 // example "notes/Linux" and "/notes/Linux" both refers to the "Linux"
@@ -273,6 +950,22 @@ func (gs *GitStorage) LogsForPage(path string) (result []CommitLog, err error) {
 // returned if the "relpath" argument refers to a file outside the wiki
 // repository.
 func (gs *GitStorage) LookupPage(relpath string) (*Page, bool, error) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+
+	if gs.bare {
+		if hasRoot, err := gs.hasRootCommit(); err != nil {
+			return nil, false, err
+		} else if !hasRoot {
+			return NewPage(cleanTreePath(relpath) + "." + DefaultExtension), false, nil
+		}
+		commit, _, err := gs.currentState()
+		if err != nil {
+			return nil, false, err
+		}
+		return gs.lookupPageInCommit(commit, relpath)
+	}
+
 	abspath, err := gs.JoinPath(relpath)
 	if err != nil {
 		return nil, false, err
@@ -294,6 +987,52 @@ func (gs *GitStorage) LookupPage(relpath string) (*Page, bool, error) {
 	return page, false, nil
 }
 
+// LookupPageAt is like LookupPage but fetches the page from the revision
+// rev, a commit id or a branch name, instead of the working directory.
+func (gs *GitStorage) LookupPageAt(rev, relpath string) (*Page, bool, error) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+
+	commit, err := gs.commitFromId(rev)
+	if err != nil {
+		return nil, false, err
+	}
+	return gs.lookupPageInCommit(commit, relpath)
+}
+
+// cleanTreePath turns a wiki path into a path relative to the root tree.
+func cleanTreePath(relpath string) string {
+	return strings.TrimPrefix(path.Clean("/"+relpath), "/")
+}
+
+func (gs *GitStorage) lookupPageInCommit(commit *git.Commit, relpath string) (*Page, bool, error) {
+	relpath = cleanTreePath(relpath)
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, false, err
+	}
+
+	for i := range PAGE_EXTENSIONS {
+		relfilename := relpath + "." + PAGE_EXTENSIONS[i]
+		entry, err := tree.EntryByPath(relfilename)
+		if err != nil || entry.Type != git.ObjectBlob {
+			continue
+		}
+		blob, err := gs.r.LookupBlob(entry.Id)
+		if err != nil {
+			return nil, true, err
+		}
+		page := NewPage(relfilename)
+		if err = page.SetRawBytes(blob.Contents()); err != nil {
+			return nil, true, err
+		}
+		page.Mtime = commit.Author().When
+		return page, true, nil
+	}
+	page := NewPage(relpath + "." + DefaultExtension)
+	return page, false, nil
+}
+
 type OidSet struct {
 	set map[*git.Oid]bool
 }
@@ -309,6 +1048,9 @@ func (o *OidSet) Add(oid *git.Oid) bool {
 }
 
 func (gs *GitStorage) GetLastCommit(path string) (*CommitLog, error) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+
 	commit, tree, err := gs.currentState()
 	if err != nil {
 		return nil, err
@@ -366,71 +1108,266 @@ func (gs *GitStorage) GetLastCommit(path string) (*CommitLog, error) {
 	return extractCommitLog(cc), nil
 }
 
-func (gs *GitStorage) SavePage(page *Page, sig *CommitSignature, message string) error {
-	fullpath := filepath.Join(gs.WorkDir, page.Path)
+func (gs *GitStorage) SavePage(page *Page, sig *CommitSignature, message string) (RevID, error) {
+	return gs.write(func() (RevID, error) {
+		if gs.bare {
+			return gs.commitChanges([]fileChange{{path: page.Path, data: page.RawBytes}}, sig, message)
+		}
 
-	if err := MkMissingDirs(fullpath); err != nil {
-		return err
-	}
+		fullpath := filepath.Join(gs.WorkDir, page.Path)
 
-	if err := ioutil.WriteFile(fullpath, page.RawBytes, 0644); err != nil {
-		return err
-	}
+		if err := MkMissingDirs(fullpath); err != nil {
+			return "", err
+		}
 
-	_, err := gs.CommitFile(page.Path, sig, message)
-	return err
+		if err := ioutil.WriteFile(fullpath, page.RawBytes, 0644); err != nil {
+			return "", err
+		}
+
+		return gs.commitFile(page.Path, sig, message)
+	})
 }
 
 func (gs *GitStorage) ListPages() ([]string, error) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+
 	var result []string
 
 	// Return early if we are on a new repository (i.e. one without a "root"
 	// commit).
-	if !gs.hasRootCommit() {
+	if hasRoot, err := gs.hasRootCommit(); err != nil {
+		return result, err
+	} else if !hasRoot {
 		return result, nil
 	}
 
+	commit, tree, err := gs.currentState()
+	if err != nil {
+		return result, err
+	}
+
+	// the list only changes with a new commit.
+	head := commit.Id().String()
+	gs.pagesMu.Lock()
+	defer gs.pagesMu.Unlock()
+	if gs.pages != nil && gs.pagesHead == head {
+		return append(result, gs.pages...), nil
+	}
+
+	if result, err = listPagesInTree(tree); err != nil {
+		return result, err
+	}
+
+	gs.pagesHead = head
+	gs.pages = append(make([]string, 0, len(result)), result...)
+	return result, nil
+}
+
+// ListPagesAt returns the pages found at the revision rev, a commit id or a
+// branch name.
+func (gs *GitStorage) ListPagesAt(rev string) ([]string, error) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+
+	tree, err := gs.treeFromId(rev)
+	if err != nil {
+		return nil, err
+	}
+	return listPagesInTree(tree)
+}
+
+func listPagesInTree(tree *git.Tree) ([]string, error) {
+	var result []string
+
 	exts := make(map[string]bool)
 	for _, ext := range PAGE_EXTENSIONS {
 		exts["."+ext] = true
 	}
 
-	_, tree, err := gs.currentState()
-	if err != nil {
-		return result, err
-	}
+	err := walkBlobs(tree, func(path string, entry *git.TreeEntry) error {
+		pageext := filepath.Ext(path)
+		if len(pageext) > 0 {
+			if _, ok := exts[pageext]; ok {
+				result = append(result, ShortenPageName(path))
+			}
+		}
+		return nil
+	})
+	return result, err
+}
 
-	err = tree.Walk(func(root string, t *git.TreeEntry) int {
+// walkBlobs calls fn for each file (blob) found in tree and its subtrees;
+// path is relative to the root of tree. The walk stops at the first error
+// returned by fn.
+func walkBlobs(tree *git.Tree, fn func(path string, entry *git.TreeEntry) error) error {
+	var fnErr error
+	err := tree.Walk(func(root string, t *git.TreeEntry) int {
 		switch git.Filemode(t.Filemode) {
 		case git.FilemodeBlob, git.FilemodeBlobExecutable:
-			pageext := filepath.Ext(t.Name)
-			if len(pageext) > 0 {
-				if _, ok := exts[pageext]; ok {
-					result = append(result, ShortenPageName(root+t.Name))
-				}
+			if fnErr = fn(root+t.Name, t); fnErr != nil {
+				return -1
 			}
 		}
 
 		// to avoid going into sibdirectories return 1
 		return 0
 	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+// WalkFiles calls fn with the path and the content of every file found
+// inside the directory dir (the whole wiki when empty) at the revision rev
+// (the last commit when empty); paths are relative to dir.
+func (gs *GitStorage) WalkFiles(rev, dir string, fn WalkFilesFunc) error {
+	var tree *git.Tree
+	var err error
+
+	// git objects never change, the lock is only needed to find the tree;
+	// this way a slow fn doesn't delay the writes.
+	gs.mu.RLock()
+	var hasRoot bool
+	if rev != "" {
+		tree, err = gs.treeFromId(rev)
+	} else if hasRoot, err = gs.hasRootCommit(); err == nil && !hasRoot {
+		err = errors.New("The repository is empty")
+	} else if err == nil {
+		_, tree, err = gs.currentState()
+	}
+	gs.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if dir = strings.Trim(dir, "/"); dir != "" {
+		entry, err := tree.EntryByPath(dir)
+		if err != nil {
+			return err
+		}
+		if entry.Type != git.ObjectTree {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		if tree, err = gs.r.LookupTree(entry.Id); err != nil {
+			return err
+		}
+	}
+
+	return walkBlobs(tree, func(path string, entry *git.TreeEntry) error {
+		blob, err := gs.r.LookupBlob(entry.Id)
+		if err != nil {
+			return err
+		}
+		return fn(path, blob.Contents())
+	})
+}
+
+// listFiles returns the path of every file found in the last commit.
+func (gs *GitStorage) listFiles() ([]string, error) {
+	var result []string
+
+	if hasRoot, err := gs.hasRootCommit(); err != nil {
+		return result, err
+	} else if !hasRoot {
+		return result, nil
+	}
+
+	_, tree, err := gs.currentState()
+	if err != nil {
+		return result, err
+	}
+
+	err = walkBlobs(tree, func(path string, entry *git.TreeEntry) error {
+		result = append(result, path)
+		return nil
+	})
 	return result, err
 }
 
-// Return the git.Tree of the specified SHA id.
-func (gs *GitStorage) treeFromId(id string) (*git.Tree, error) {
+// commitFromId returns the commit with the given id or the last commit of
+// the branch called id.
+func (gs *GitStorage) commitFromId(id string) (*git.Commit, error) {
+	if !revisionRe.MatchString(id) {
+		ref, err := gs.r.References.Lookup(branchPrefix + id)
+		if err != nil {
+			return nil, err
+		}
+		return gs.r.LookupCommit(ref.Target())
+	}
+
 	oid, err := git.NewOid(id)
 	if err != nil {
 		return nil, err
 	}
-	commit, err := gs.r.LookupCommit(oid)
+	return gs.r.LookupCommit(oid)
+}
+
+// Return the git.Tree of the specified SHA id.
+func (gs *GitStorage) treeFromId(id string) (*git.Tree, error) {
+	commit, err := gs.commitFromId(id)
 	if err != nil {
 		return nil, err
 	}
 	return commit.Tree()
 }
 
+// CommitDiff returns the patch introduced by the commit "rev".
+func (gs *GitStorage) CommitDiff(rev RevID) (string, error) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+
+	commit, err := gs.commitFromId(string(rev))
+	if err != nil {
+		return "", err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", err
+	}
+
+	// the root commit is compared with an empty tree
+	var parentTree *git.Tree
+	if commit.ParentCount() > 0 {
+		if parentTree, err = commit.Parent(0).Tree(); err != nil {
+			return "", err
+		}
+	}
+
+	diffopts, err := git.DefaultDiffOptions()
+	if err != nil {
+		return "", err
+	}
+	diff, err := gs.r.DiffTreeToTree(parentTree, tree, &diffopts)
+	if err != nil {
+		return "", err
+	}
+
+	dlen, err := diff.NumDeltas()
+	if err != nil {
+		return "", err
+	}
+	var result string
+	for i := 0; i < dlen; i++ {
+		patch, err := diff.Patch(i)
+		if err != nil {
+			return "", err
+		}
+		patchStr, err := patch.String()
+		if err != nil {
+			return "", err
+		}
+		result += patchStr
+	}
+
+	return result, nil
+}
+
 func (gs *GitStorage) DiffPage(page *Page, revA, revB string) ([]string, error) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+
 	// commit A
 	oldTree, err := gs.treeFromId(revA)
 	if err != nil {
@@ -1,10 +1,13 @@
 package spock
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -43,6 +46,7 @@ func createTestRepo(t *testing.T) *GitStorage {
 }
 
 func cleanup(t *testing.T, gs *GitStorage) {
+	gs.Close()
 	err := os.RemoveAll(gs.WorkDir)
 	checkFatal(t, err)
 }
@@ -211,3 +215,334 @@ func TestListPages(t *testing.T) {
 		t.Fatalf("There should be 2 pages, there are %d", len(pages))
 	}
 }
+
+func TestListPagesCache(t *testing.T) {
+	gs := createTestRepo(t)
+	defer cleanup(t, gs)
+
+	createTestPage(t, gs, "index.md", "this is my index", "test user", "test@email.com", "created", time.Now())
+	pages, err := gs.ListPages()
+	checkFatal(t, err)
+	if len(pages) != 1 {
+		t.Fatalf("There should be 1 page, there are %d", len(pages))
+	}
+
+	createTestPage(t, gs, "foobar.md", "my foobar page!", "test user", "test@email.com", "created", time.Now())
+	pages, err = gs.ListPages()
+	checkFatal(t, err)
+	if len(pages) != 2 {
+		t.Fatalf("There should be 2 pages after a new commit, there are %d", len(pages))
+	}
+}
+
+func TestRestorePage(t *testing.T) {
+	gs := createTestRepo(t)
+	defer cleanup(t, gs)
+
+	createTestPage(t, gs, "index.md", "this is my index", "test user", "test@email.com", "created", time.Now())
+	createTestPage(t, gs, "foobar.md", "my foobar page!", "test user", "test@email.com", "created", time.Now())
+	sig := createSignature(t)
+
+	_, err := gs.DeletePage("foobar.md", sig, "get rid of foobar.md")
+	checkFatal(t, err)
+	_, err = gs.RenamePage("index.md", "home.md", sig, "rename index.md")
+	checkFatal(t, err)
+
+	deleted, err := gs.DeletedPages()
+	checkFatal(t, err)
+	if len(deleted) != 1 {
+		t.Fatalf("There should be 1 deleted page, there are %d", len(deleted))
+	}
+	if deleted[0].Path != "foobar.md" {
+		t.Fatalf("Deleted page should be \"foobar.md\", is \"%s\"", deleted[0].Path)
+	}
+
+	_, err = gs.RestorePage(deleted[0].Path, deleted[0].Commit.Id, sig, "restore foobar.md")
+	checkFatal(t, err)
+
+	page, exists, err := gs.LookupPage("foobar")
+	checkFatal(t, err)
+	if !exists || string(page.RawBytes) != "my foobar page!" {
+		t.Fatalf("foobar.md was not restored")
+	}
+
+	deleted, err = gs.DeletedPages()
+	checkFatal(t, err)
+	if len(deleted) != 0 {
+		t.Fatalf("There should be no deleted pages, there are %d", len(deleted))
+	}
+}
+
+func TestRevertPage(t *testing.T) {
+	gs := createTestRepo(t)
+	defer cleanup(t, gs)
+
+	createTestPage(t, gs, "index.md", "first version", "test user", "test@email.com", "created", time.Now())
+	createTestPage(t, gs, "index.md", "second version", "test user", "test@email.com", "modified", time.Now())
+
+	logs, err := gs.LogsForPage("index.md")
+	checkFatal(t, err)
+	if len(logs) != 2 {
+		t.Fatalf("There should be 2 logs, there are %d", len(logs))
+	}
+
+	revId, err := gs.RevertPage("index.md", logs[1].Id, createSignature(t), "revert index.md")
+	checkFatal(t, err)
+
+	page, _, err := gs.LookupPage("index")
+	checkFatal(t, err)
+	if string(page.RawBytes) != "first version" {
+		t.Fatalf("Page content should be \"first version\", is \"%s\"", page.RawBytes)
+	}
+
+	diff, err := gs.CommitDiff(revId)
+	checkFatal(t, err)
+	if !strings.Contains(diff, "+first version") {
+		t.Fatalf("Diff of the revert commit is wrong: %s", diff)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	gs := createTestRepo(t)
+	defer cleanup(t, gs)
+
+	createTestPage(t, gs, "index.md", "this is my index", "test user", "test@email.com", "created", time.Now())
+
+	const workers = 8
+	const rounds = 5
+
+	sig := createSignature(t)
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*rounds*3)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				name := fmt.Sprintf("worker%d/page%d", i, j)
+				page := NewPage(name + ".md")
+				if err := page.SetRawBytes([]byte(fmt.Sprintf("page %d of worker %d", j, i))); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := gs.SavePage(page, sig, "save "+name); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := gs.RenamePage(name+".md", name+"-renamed.md", sig, "rename "+name); err != nil {
+					errs <- err
+					return
+				}
+				// keep the even pages
+				if j%2 == 1 {
+					if _, err := gs.DeletePage(name+"-renamed.md", sig, "delete "+name); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(i)
+	}
+
+	// readers running together with the writers
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < rounds*3; j++ {
+				if _, err := gs.ListPages(); err != nil {
+					errs <- err
+					return
+				}
+				if _, _, err := gs.LookupPage("index"); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	pages, err := gs.ListPages()
+	checkFatal(t, err)
+	expected := 1 + workers*((rounds+1)/2)
+	if len(pages) != expected {
+		t.Fatalf("There should be %d pages, there are %d: %v", expected, len(pages), pages)
+	}
+	for _, page := range pages {
+		if page == "index" {
+			continue
+		}
+		if !strings.HasSuffix(page, "-renamed") {
+			t.Fatalf("Unexpected page %s", page)
+		}
+		if _, err := os.Stat(filepath.Join(gs.WorkDir, page+".md")); err != nil {
+			t.Fatalf("Page %s is missing from the working directory: %s", page, err)
+		}
+	}
+
+	// no commit should have picked up a change to another page
+	logs, err := gs.LogsForPage("index.md")
+	checkFatal(t, err)
+	if len(logs) != 1 {
+		t.Fatalf("index.md should have 1 commit, has %d", len(logs))
+	}
+}
+
+func TestDefaultBranch(t *testing.T) {
+	gs := createTestRepo(t)
+	defer cleanup(t, gs)
+
+	checkFatal(t, gs.SetBranch("main"))
+	createTestPage(t, gs, "index.md", "this is my index", "test user", "test@email.com", "created", time.Now())
+	createTestPage(t, gs, "foobar.md", "my foobar page!", "test user", "test@email.com", "created", time.Now())
+
+	branch, err := gs.CurrentBranch()
+	checkFatal(t, err)
+	if branch != "main" {
+		t.Fatalf("The current branch should be main, is %s", branch)
+	}
+
+	pages, err := gs.ListPages()
+	checkFatal(t, err)
+	if len(pages) != 2 {
+		t.Fatalf("There should be 2 pages, there are %d", len(pages))
+	}
+
+	commit, _, err := gs.currentState()
+	checkFatal(t, err)
+	if commit.ParentCount() != 1 {
+		t.Fatalf("The last commit should have 1 parent, has %d", commit.ParentCount())
+	}
+
+	if err = gs.SetBranch("master"); err == nil {
+		t.Fatal("Changing the branch of a repository with commits should fail")
+	}
+}
+
+func TestDetachedHead(t *testing.T) {
+	gs := createTestRepo(t)
+	defer cleanup(t, gs)
+
+	createTestPage(t, gs, "index.md", "this is my index", "test user", "test@email.com", "created", time.Now())
+	commit, _, err := gs.currentState()
+	checkFatal(t, err)
+	_, err = gs.r.References.Create("HEAD", commit.Id(), true, "detach HEAD")
+	checkFatal(t, err)
+
+	// the repository is not empty
+	if _, err = gs.ListPages(); err == nil {
+		t.Fatal("Listing the pages with a detached HEAD should fail")
+	}
+	page := NewPage("foobar.md")
+	checkFatal(t, page.SetRawBytes([]byte("my foobar page!")))
+	if _, err = gs.SavePage(page, createSignature(t), "created"); err == nil {
+		t.Fatal("Saving a page with a detached HEAD should fail")
+	}
+	if _, err = os.Stat(filepath.Join(gs.WorkDir, "foobar.md")); !os.IsNotExist(err) {
+		t.Fatal("Nothing should be written with a detached HEAD")
+	}
+}
+
+func TestBrowseBranch(t *testing.T) {
+	gs := createTestRepo(t)
+	defer cleanup(t, gs)
+
+	createTestPage(t, gs, "index.md", "old index", "test user", "test@email.com", "created", time.Now())
+	commit, _, err := gs.currentState()
+	checkFatal(t, err)
+	_, err = gs.r.References.Create("refs/heads/draft", commit.Id(), false, "create draft")
+	checkFatal(t, err)
+
+	createTestPage(t, gs, "index.md", "new index", "test user", "test@email.com", "updated", time.Now())
+	createTestPage(t, gs, "foobar.md", "my foobar page!", "test user", "test@email.com", "created", time.Now())
+
+	branches, err := gs.Branches()
+	checkFatal(t, err)
+	if strings.Join(branches, ",") != "draft,master" {
+		t.Fatalf("Branches should be [draft master], are %v", branches)
+	}
+
+	page, exists, err := gs.LookupPageAt("draft", "index")
+	checkFatal(t, err)
+	if !exists || string(page.RawBytes) != "old index" {
+		t.Fatalf("index on the draft branch should contain \"old index\", is %+v", page)
+	}
+	if _, exists, _ = gs.LookupPageAt("draft", "foobar"); exists {
+		t.Fatal("foobar should not exist on the draft branch")
+	}
+
+	pages, err := gs.ListPagesAt("draft")
+	checkFatal(t, err)
+	if len(pages) != 1 {
+		t.Fatalf("There should be 1 page on the draft branch, there are %d", len(pages))
+	}
+}
+
+func TestBareRepository(t *testing.T) {
+	path, err := ioutil.TempDir("", "spock")
+	checkFatal(t, err)
+	gs, err := CreateBareGitStorage(path)
+	checkFatal(t, err)
+	defer cleanup(t, gs)
+
+	if !gs.IsBare() {
+		t.Fatal("The repository should be bare")
+	}
+
+	sig := createSignature(t)
+	for _, filename := range []string{"index.md", "docs/linux/kernel.md"} {
+		page := NewPage(filename)
+		checkFatal(t, page.SetRawBytes([]byte("content of "+filename)))
+		_, err = gs.SavePage(page, sig, "create "+filename)
+		checkFatal(t, err)
+	}
+
+	if _, err = os.Stat(filepath.Join(path, "index.md")); !os.IsNotExist(err) {
+		t.Fatal("A bare repository should not have a working directory")
+	}
+
+	page, exists, err := gs.LookupPage("docs/linux/kernel")
+	checkFatal(t, err)
+	if !exists || string(page.RawBytes) != "content of docs/linux/kernel.md" {
+		t.Fatalf("docs/linux/kernel should exist: %+v", page)
+	}
+
+	_, err = gs.RenamePage("docs/linux/kernel.md", "kernel.md", sig, "move kernel")
+	checkFatal(t, err)
+	_, err = gs.DeletePage("index.md", sig, "delete index")
+	checkFatal(t, err)
+
+	pages, err := gs.ListPages()
+	checkFatal(t, err)
+	if len(pages) != 1 || pages[0] != "kernel" {
+		t.Fatalf("The only page should be kernel, pages are %v", pages)
+	}
+
+	// the "docs" directory was left empty and must be gone
+	_, tree, err := gs.currentState()
+	checkFatal(t, err)
+	if tree.EntryCount() != 1 {
+		t.Fatalf("The root tree should contain 1 entry, contains %d", tree.EntryCount())
+	}
+
+	data, _, err := gs.ReadFile("kernel.md")
+	checkFatal(t, err)
+	if string(data) != "content of docs/linux/kernel.md" {
+		t.Fatalf("Unexpected content of kernel.md: %q", data)
+	}
+	if _, _, err = gs.ReadFile("index.md"); !os.IsNotExist(err) {
+		t.Fatalf("Reading a deleted file should fail with a not found error, got %v", err)
+	}
+
+	logs, err := gs.LogsForPage("kernel.md")
+	checkFatal(t, err)
+	if len(logs) != 1 {
+		t.Fatalf("kernel.md should have 1 commit, has %d", len(logs))
+	}
+}
@@ -0,0 +1,445 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// Conversion of an existing Gollum wiki to the Spock conventions.
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Gollum page extensions that Spock can render; the value is the extension
+// used by Spock.
+var gollumExtensions = map[string]string{
+	".md":       ".md",
+	".markdown": ".md",
+	".mdown":    ".md",
+	".mkdn":     ".md",
+	".mkd":      ".md",
+	".rst":      ".rst",
+	".org":      ".org",
+	".txt":      ".txt",
+}
+
+// Gollum page extensions for markups that Spock can't render.
+var gollumUnsupportedExtensions = map[string]bool{
+	".textile":   true,
+	".mediawiki": true,
+	".wiki":      true,
+	".asciidoc":  true,
+	".creole":    true,
+	".pod":       true,
+	".rdoc":      true,
+}
+
+// Gollum special pages and their Spock counterparts.
+var gollumSpecialPages = map[string]string{
+	"Home":     "index",
+	"_Sidebar": SidebarPageName,
+	"_Footer":  FooterPageName,
+}
+
+// Extensions of the files that Gollum embeds as images.
+var gollumImageExtensions = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+	".svg":  true,
+}
+
+// Gollum tags are [[...]]; a leading quote is used to escape them.
+var gollumTagRe = regexp.MustCompile(`(')?\[\[([^\]]+)\]\]`)
+
+// Tells apart file extensions from dots in the name of a page.
+var gollumFileExtRe = regexp.MustCompile(`^\.[a-z0-9]{1,5}$`)
+
+// GollumProblem is a Gollum construct that could not be translated.
+type GollumProblem struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+func (p GollumProblem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.Path, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// GollumReport describes the outcome of ImportGollum.
+type GollumReport struct {
+	// Renamed maps the old filename of a page to the new one.
+	Renamed map[string]string
+	// Converted lists the pages whose content was modified.
+	Converted []string
+	Problems  []GollumProblem
+	RevID     RevID
+}
+
+func (r *GollumReport) addProblem(path string, line int, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, GollumProblem{
+		Path:    path,
+		Line:    line,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// normalizeGollumName returns the key used to match Gollum page names:
+// Gollum links are case insensitive and treat spaces and dashes alike.
+func normalizeGollumName(name string) string {
+	return strings.ToLower(strings.Replace(name, "-", " ", -1))
+}
+
+// gollumToSpockName converts the name of a Gollum page, without the
+// extension and the directory, to the name used by Spock.
+func gollumToSpockName(name string) string {
+	if special, ok := gollumSpecialPages[name]; ok {
+		return special
+	}
+	return strings.Replace(name, " ", "-", -1)
+}
+
+type gollumConverter struct {
+	// normalized path of a page, without extension -> Spock wiki path
+	byPath map[string]string
+	// normalized name of a page -> Spock wiki paths
+	byName map[string][]string
+	report *GollumReport
+}
+
+func newGollumConverter(report *GollumReport) *gollumConverter {
+	return &gollumConverter{
+		byPath: make(map[string]string),
+		byName: make(map[string][]string),
+		report: report,
+	}
+}
+
+func (gc *gollumConverter) addPage(oldPath, newPath string) {
+	oldName := ShortenPageName(oldPath)
+	wikiPath := ShortenPageName(newPath)
+	gc.byPath[normalizeGollumName(oldName)] = wikiPath
+	key := normalizeGollumName(path.Base(oldName))
+	gc.byName[key] = append(gc.byName[key], wikiPath)
+}
+
+// resolve finds the Spock wiki path of a Gollum link target; links
+// containing a slash are relative to the root of the wiki, while bare page
+// names are searched in the whole wiki, preferring the directory of the
+// linking page.
+func (gc *gollumConverter) resolve(dir, target string) (string, bool) {
+	if strings.Contains(target, "/") {
+		target = strings.TrimPrefix(target, "/")
+		if wikiPath, ok := gc.byPath[normalizeGollumName(target)]; ok {
+			return wikiPath, true
+		}
+		return path.Join(path.Dir(target), gollumToSpockName(path.Base(target))), false
+	}
+
+	candidates := gc.byName[normalizeGollumName(target)]
+	for _, candidate := range candidates {
+		if path.Dir(candidate) == dir {
+			return candidate, true
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[0], true
+	}
+	return path.Join(dir, gollumToSpockName(target)), false
+}
+
+// convertTag converts the content of a single Gollum tag, returning the
+// replacement text.
+func (gc *gollumConverter) convertTag(filename string, line int, dir, markup, tag string) string {
+	if tag == "_TOC_" {
+		if markup == markdownName {
+			gc.report.addProblem(filename, line, "[[_TOC_]] removed: Markdown pages always get a table of contents")
+		} else {
+			gc.report.addProblem(filename, line, "[[_TOC_]] removed: not supported")
+		}
+		return ""
+	}
+	if strings.HasPrefix(tag, "include:") {
+		gc.report.addProblem(filename, line, "page inclusion is not supported: [[%s]]", tag)
+		return "[[" + tag + "]]"
+	}
+
+	parts := strings.Split(tag, "|")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+
+	// links to files: [[path/file.ext|option|...]]
+	ext := strings.ToLower(path.Ext(parts[0]))
+	_, isPage := gollumExtensions[ext]
+	if gollumFileExtRe.MatchString(ext) && !isPage {
+		return gc.convertFileTag(filename, line, dir, markup, parts)
+	}
+
+	var text, target string
+	switch len(parts) {
+	case 1:
+		text, target = parts[0], parts[0]
+	case 2:
+		text, target = parts[0], parts[1]
+	default:
+		gc.report.addProblem(filename, line, "cannot parse link: [[%s]]", tag)
+		return "[[" + tag + "]]"
+	}
+
+	var anchor string
+	if i := strings.Index(target, "#"); i != -1 {
+		target, anchor = target[:i], target[i:]
+	}
+
+	href := anchor
+	if target != "" {
+		if _, ok := gollumExtensions[strings.ToLower(path.Ext(target))]; ok {
+			target = ShortenPageName(target)
+		}
+		wikiPath, found := gc.resolve(dir, target)
+		if !found {
+			gc.report.addProblem(filename, line, "link to a missing page: [[%s]]", tag)
+		}
+		href = "/" + wikiPath + anchor
+	}
+
+	return formatLink(markup, text, href)
+}
+
+func (gc *gollumConverter) convertFileTag(filename string, line int, dir, markup string, parts []string) string {
+	target := parts[0]
+	href := target
+	if !strings.Contains(target, "://") {
+		if strings.HasPrefix(target, "/") {
+			href = target
+		} else {
+			href = "/" + path.Join(dir, target)
+		}
+	}
+
+	alt := path.Base(target)
+	for _, option := range parts[1:] {
+		if strings.HasPrefix(option, "alt=") {
+			alt = strings.TrimPrefix(option, "alt=")
+		} else {
+			gc.report.addProblem(filename, line, "file option ignored: %s", option)
+		}
+	}
+
+	if !gollumImageExtensions[strings.ToLower(path.Ext(target))] {
+		return formatLink(markup, alt, href)
+	}
+
+	switch markup {
+	case markdownName:
+		return fmt.Sprintf("![%s](%s)", alt, href)
+	case orgName:
+		return fmt.Sprintf("[[%s]]", href)
+	default:
+		gc.report.addProblem(filename, line, "inline image converted to a link: %s", target)
+		return formatLink(markup, alt, href)
+	}
+}
+
+// formatLink returns a link written in the specified markup.
+func formatLink(markup, text, href string) string {
+	switch markup {
+	case rstName:
+		return fmt.Sprintf("`%s <%s>`__", text, href)
+	case orgName:
+		return fmt.Sprintf("[[%s][%s]]", href, text)
+	default:
+		return fmt.Sprintf("[%s](%s)", text, href)
+	}
+}
+
+// convert translates the Gollum tags found in the content of a page,
+// skipping fenced code blocks.
+func (gc *gollumConverter) convert(filename, markup string, content []byte) ([]byte, error) {
+	var out bytes.Buffer
+	dir := path.Dir(filename)
+
+	// the marker of the fenced code block containing the line, if any
+	var fence string
+	lineno := 0
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	// no line is longer than the page (e.g. embedded images)
+	scanner.Buffer(make([]byte, 0, 64*1024), len(content)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineno++
+
+		trimmed := strings.TrimSpace(line)
+		inCode := fence != ""
+		if !inCode && (strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")) {
+			fence = trimmed[:3]
+			inCode = true
+		} else if inCode && strings.HasPrefix(trimmed, fence) {
+			fence = ""
+		}
+		if !inCode {
+			line = gollumTagRe.ReplaceAllStringFunc(line, func(match string) string {
+				m := gollumTagRe.FindStringSubmatch(match)
+				if m[1] != "" {
+					// escaped tag: drop the quote and keep it verbatim
+					return match[1:]
+				}
+				return gc.convertTag(filename, lineno, dir, markup, m[2])
+			})
+			if strings.Contains(line, "<<") && strings.Contains(line, ">>") {
+				gc.report.addProblem(filename, lineno, "Gollum macros are not supported")
+			}
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	result := out.Bytes()
+	if !bytes.HasSuffix(content, []byte("\n")) && len(result) > 0 {
+		result = result[:len(result)-1]
+	}
+	return result, nil
+}
+
+// ImportGollum converts the Gollum wiki stored in gs to the Spock
+// conventions: pages are renamed following the Spock naming rules, Gollum
+// links are rewritten using the syntax of each page markup and _Sidebar
+// and _Footer become the Spock sidebar and footer pages.
+// All the changes are recorded in a single commit, on top of the existing
+// history of the wiki.
+func ImportGollum(gs *GitStorage, sig *CommitSignature) (*GollumReport, error) {
+	if gs.IsBare() {
+		return nil, errors.New("Cannot import a Gollum wiki into a bare repository")
+	}
+
+	report := &GollumReport{Renamed: make(map[string]string)}
+	// the import is a write like any other, serialised with the others.
+	revId, err := gs.write(func() (RevID, error) {
+		return importGollum(gs, report, sig)
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.RevID = revId
+	return report, nil
+}
+
+// importGollum runs ImportGollum, from the writer goroutine of gs; it
+// returns the id of the import commit or, when nothing changed, an empty
+// RevID.
+func importGollum(gs *GitStorage, report *GollumReport, sig *CommitSignature) (RevID, error) {
+	files, err := gs.listFiles()
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	existing := make(map[string]bool)
+	for _, filename := range files {
+		existing[filename] = true
+	}
+
+	gc := newGollumConverter(report)
+	var pages []string
+	for _, filename := range files {
+		ext := strings.ToLower(path.Ext(filename))
+		if gollumUnsupportedExtensions[ext] {
+			report.addProblem(filename, 0, "markup not supported by Spock, page left untouched")
+			continue
+		}
+		newExt, ok := gollumExtensions[ext]
+		if !ok {
+			continue
+		}
+
+		name := path.Base(ShortenPageName(filename))
+		if name == "_Header" {
+			report.addProblem(filename, 0, "page headers are not supported")
+		}
+		newPath := path.Join(path.Dir(filename), gollumToSpockName(name)+newExt)
+		if newPath != filename {
+			if existing[newPath] {
+				report.addProblem(filename, 0, "cannot rename to %s: file exists", newPath)
+				newPath = filename
+			} else {
+				report.Renamed[filename] = newPath
+				existing[newPath] = true
+			}
+		}
+		gc.addPage(filename, newPath)
+		pages = append(pages, filename)
+	}
+
+	idx, err := gs.r.Index()
+	if err != nil {
+		return "", err
+	}
+
+	changed := false
+	for _, filename := range pages {
+		newPath, renamed := report.Renamed[filename]
+		if !renamed {
+			newPath = filename
+		}
+
+		data, err := ioutil.ReadFile(gs.MakeAbsPath(filename))
+		if err != nil {
+			return "", err
+		}
+		page := NewPage(newPath)
+		converted, err := gc.convert(filename, page.GetMarkup(), data)
+		if err != nil {
+			return "", err
+		}
+		modified := !bytes.Equal(converted, data)
+
+		if !modified && !renamed {
+			continue
+		}
+		if modified {
+			report.Converted = append(report.Converted, newPath)
+		}
+		if renamed {
+			if err = os.Rename(gs.MakeAbsPath(filename), gs.MakeAbsPath(newPath)); err != nil {
+				return "", err
+			}
+			if err = idx.RemoveByPath(filename); err != nil {
+				return "", err
+			}
+		}
+		if err = ioutil.WriteFile(gs.MakeAbsPath(newPath), converted, 0644); err != nil {
+			return "", err
+		}
+		if err = idx.AddByPath(newPath); err != nil {
+			return "", err
+		}
+		changed = true
+	}
+
+	if !changed {
+		return "", nil
+	}
+
+	commitId, err := gs.saveIndex(idx, sig, "Import Gollum wiki")
+	if err != nil {
+		return "", err
+	}
+	return RevID(commitId.String()), nil
+}
@@ -0,0 +1,83 @@
+package spock
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestGollumConverter() (*gollumConverter, *GollumReport) {
+	report := &GollumReport{Renamed: make(map[string]string)}
+	gc := newGollumConverter(report)
+	gc.addPage("Home.md", "index.md")
+	gc.addPage("My Page.markdown", "My-Page.md")
+	gc.addPage("notes/Linux Tips.md", "notes/Linux-Tips.md")
+	return gc, report
+}
+
+func TestGollumToSpockName(t *testing.T) {
+	tests := map[string]string{
+		"Home":       "index",
+		"_Sidebar":   SidebarPageName,
+		"_Footer":    FooterPageName,
+		"My Page":    "My-Page",
+		"Already-Ok": "Already-Ok",
+	}
+	for name, expected := range tests {
+		if rv := gollumToSpockName(name); rv != expected {
+			t.Fatalf("gollumToSpockName(%q) should be %q, is %q", name, expected, rv)
+		}
+	}
+}
+
+func TestGollumConvertMarkdown(t *testing.T) {
+	gc, report := newTestGollumConverter()
+
+	content := []byte("See [[Home]], [[the tips|Linux-Tips]] and [[my page#intro]].\n" +
+		"'[[Escaped]]\n" +
+		"```\n[[Home]]\n```\n" +
+		"~~~\n[[Home]]\n```\n[[Home]]\n~~~\n" +
+		"[[_TOC_]]\n" +
+		"[[/images/logo.png|alt=Logo]]\n")
+	expected := "See [Home](/index), [the tips](/notes/Linux-Tips) and [my page#intro](/My-Page#intro).\n" +
+		"[[Escaped]]\n" +
+		"```\n[[Home]]\n```\n" +
+		"~~~\n[[Home]]\n```\n[[Home]]\n~~~\n" +
+		"\n" +
+		"![Logo](/images/logo.png)\n"
+
+	converted, err := gc.convert("Home.md", markdownName, content)
+	checkFatal(t, err)
+	if rv := string(converted); rv != expected {
+		t.Fatalf("converted content should be:\n%s\nis:\n%s", expected, rv)
+	}
+	if len(report.Problems) != 1 {
+		t.Fatalf("there should be 1 problem, there are %d: %v", len(report.Problems), report.Problems)
+	}
+}
+
+func TestGollumConvertMissingPage(t *testing.T) {
+	gc, report := newTestGollumConverter()
+
+	converted, err := gc.convert("notes/Linux Tips.md", rstName, []byte("[[Other Page]]"))
+	checkFatal(t, err)
+	expected := "`Other Page </notes/Other-Page>`__"
+	if rv := string(converted); rv != expected {
+		t.Fatalf("converted content should be %q, is %q", expected, rv)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Line != 1 {
+		t.Fatalf("a missing page should be reported on line 1: %v", report.Problems)
+	}
+}
+
+func TestGollumConvertLongLines(t *testing.T) {
+	gc, _ := newTestGollumConverter()
+
+	// e.g. an embedded image
+	image := "![logo](data:image/png;base64," + strings.Repeat("A", 200*1024) + ")"
+	content := []byte(image + "\n[[Home]]\n")
+	converted, err := gc.convert("Home.md", markdownName, content)
+	checkFatal(t, err)
+	if expected := image + "\n[Home](/index)\n"; string(converted) != expected {
+		t.Fatalf("the page was truncated: %d bytes instead of %d", len(converted), len(expected))
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// Syntax highlighting of the code blocks, done on the rendered HTML so that
+// it works with every markup.
+
+import (
+	"bytes"
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"html"
+	"regexp"
+)
+
+// DefaultHighlightStyle is the style used when the configuration doesn't
+// set one.
+const DefaultHighlightStyle = "github"
+
+// Highlight configures the syntax highlighting of the code blocks.
+var Highlight = HighlightConfig{Style: DefaultHighlightStyle}
+
+// the code blocks written by blackfriday and by the built-in renderers;
+// older blackfriday versions omit the "language-" prefix.
+var codeBlockRe = regexp.MustCompile(`(?s)<pre><code class="(?:language-)?([^"\s]+)">(.*?)</code></pre>`)
+
+// highlightCode replaces the code blocks of a rendered page, whose language
+// is known, with their highlighted version. The colors are set with inline
+// styles so that the HTML doesn't need any stylesheet.
+func highlightCode(content []byte) []byte {
+	if Highlight.Disabled || !bytes.Contains(content, []byte("<pre><code class=")) {
+		return content
+	}
+
+	styleName := Highlight.Style
+	if styleName == "" {
+		styleName = DefaultHighlightStyle
+	}
+	style := styles.Get(styleName)
+	formatter := chromahtml.New(
+		chromahtml.WithLineNumbers(Highlight.LineNumbers),
+		chromahtml.LineNumbersInTable(true),
+	)
+
+	return codeBlockRe.ReplaceAllFunc(content, func(block []byte) []byte {
+		m := codeBlockRe.FindSubmatch(block)
+		lexer := lexers.Get(html.UnescapeString(string(m[1])))
+		if lexer == nil {
+			return block
+		}
+
+		code := html.UnescapeString(string(m[2]))
+		iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+		if err != nil {
+			return block
+		}
+		var buf bytes.Buffer
+		if err := formatter.Format(&buf, style, iterator); err != nil {
+			return block
+		}
+		return buf.Bytes()
+	})
+}
@@ -0,0 +1,42 @@
+package spock
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHighlightCode(t *testing.T) {
+	pages := map[string]string{
+		"code.md":  "```go\nfunc main() {}\n```\n",
+		"code.rst": ".. code-block:: go\n\n   func main() {}\n",
+		"code.org": "#+BEGIN_SRC go\nfunc main() {}\n#+END_SRC\n",
+	}
+	for filename, content := range pages {
+		page := NewPage(filename)
+		checkFatal(t, page.SetRawBytes([]byte(content)))
+		html, err := page.Render(nil)
+		checkFatal(t, err)
+		if !strings.Contains(string(html), `<span style="`) || strings.Contains(string(html), "language-go") {
+			t.Errorf("%s: the code block should be highlighted:\n%s", filename, html)
+		}
+	}
+
+	// unknown languages are left untouched
+	block := `<pre><code class="language-nosuchlanguage">a &lt; b</code></pre>`
+	if result := string(highlightCode([]byte(block))); result != block {
+		t.Errorf("expected %q, got %q", block, result)
+	}
+
+	defer func(h HighlightConfig) { Highlight = h }(Highlight)
+	Highlight = HighlightConfig{LineNumbers: true}
+	result := string(highlightCode([]byte(`<pre><code class="language-python">a = 1
+b = 2
+</code></pre>`)))
+	if !strings.Contains(result, ">2\n<") {
+		t.Errorf("expected line numbers:\n%s", result)
+	}
+	Highlight.Disabled = true
+	if result := string(highlightCode([]byte(block))); result != block {
+		t.Errorf("highlighting should be disabled: %s", result)
+	}
+}
@@ -9,8 +9,10 @@ import (
 	"go.marzhillstudios.com/pkg/go-html-transform/h5"
 	"go.marzhillstudios.com/pkg/go-html-transform/html/transform"
 	"golang.org/x/net/html"
+	stdhtml "html"
+	"net/url"
 	"path"
-	"strings"
+	"regexp"
 )
 
 const (
@@ -44,13 +46,18 @@ func (lc LinkCollector) Find(rootNode *html.Node) []*html.Node {
 		if node.Data != "a" {
 			return
 		}
-		href := getAttribute(node, "href")
+		u, err := url.Parse(getAttribute(node, "href"))
+		if err != nil {
+			return
+		}
 
-		// exclude external URLs
-		if strings.Index(href, "://") != -1 {
+		// exclude external URLs and links to the current page (e.g.
+		// "#section")
+		if u.Scheme != "" || u.Host != "" || u.Path == "" {
 			return
 		}
 
+		href := u.Path
 		if href[0] != '/' {
 			href = path.Join(lc.Path, href)
 		}
@@ -99,3 +106,21 @@ func AddCSSClasses(pages []string, path string, html []byte) ([]byte, error) {
 	}
 	return wrt.Bytes(), nil
 }
+
+var linkAttrRe = regexp.MustCompile(`\b(href|src)="([^"]*)"`)
+
+// rebaseLinks makes absolute the relative links and images of a page found
+// in the directory dir, so that they keep working when the HTML is shown
+// inside another page (e.g. when the page is included).
+func rebaseLinks(dir string, content []byte) []byte {
+	return linkAttrRe.ReplaceAllFunc(content, func(attr []byte) []byte {
+		m := linkAttrRe.FindSubmatch(attr)
+		u, err := url.Parse(stdhtml.UnescapeString(string(m[2])))
+		// skip external URLs, absolute paths and links to the same page
+		if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || u.Path[0] == '/' {
+			return attr
+		}
+		u.Path = "/" + cleanTreePath(path.Join(dir, u.Path))
+		return []byte(string(m[1]) + `="` + stdhtml.EscapeString(u.String()) + `"`)
+	})
+}
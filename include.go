@@ -0,0 +1,155 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// Transclusion: a line containing only "{{include: path}}" is replaced by
+// the rendered content of the page path, in its own markup.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// MaxIncludeDepth is the maximum nesting level of included pages.
+const MaxIncludeDepth = 5
+
+// LookupFunc finds a page by path, like Storage.LookupPage.
+type LookupFunc func(path string) (*Page, bool, error)
+
+var includeRe = regexp.MustCompile(`^\s*\{\{include:\s*([^{}]+?)\s*\}\}\s*$`)
+
+// renderedPage is a page rendered with placeholders in place of the
+// include directives.
+type renderedPage struct {
+	content []byte
+	// path of the included pages, by placeholder number.
+	includes []string
+}
+
+// includeToken returns the placeholder of the n-th include directive; it's
+// a single word that every renderer leaves untouched.
+func includeToken(n int) string {
+	return fmt.Sprintf("spockinclude%dplaceholder", n)
+}
+
+// replaceIncludes replaces the include directives found in content, the
+// body of page, with placeholders.
+func (page *Page) replaceIncludes(content []byte) ([]byte, []string, error) {
+	if !bytes.Contains(content, []byte("{{include:")) {
+		return content, nil, nil
+	}
+
+	var includes []string
+	dir := path.Dir(page.Path)
+	content, err := mapTextLines(page.GetMarkup(), content, func(line string) string {
+		m := includeRe.FindStringSubmatch(line)
+		if m == nil {
+			return line
+		}
+		includes = append(includes, wikiPagePath(dir, m[1]))
+		// the placeholder must be a paragraph of its own
+		return "\n" + includeToken(len(includes)-1) + "\n"
+	})
+	return content, includes, err
+}
+
+// renderParts renders content, the body of page, as HTML or as plain text,
+// without the included pages.
+func (page *Page) renderParts(content []byte, plain bool) (*renderedPage, error) {
+	markup := page.GetMarkup()
+	content, err := expandWikiLinks(markup, content)
+	if err != nil {
+		return &renderedPage{}, err
+	}
+	content, includes, err := page.replaceIncludes(content)
+	if err != nil {
+		return &renderedPage{}, err
+	}
+
+	var out []byte
+	if plain {
+		out, err = renderPlaintext(markup, content)
+	} else {
+		out, err = renderMarkup(markup, content)
+		out = sanitizeHTML(page.Path, out)
+	}
+	return &renderedPage{out, includes}, err
+}
+
+// renderIncludes replaces the placeholders of rendered, the rendering of
+// page, with the included pages; stack contains the path of the pages
+// including page and is used to detect cycles.
+func renderIncludes(rc *RenderCache, lookup LookupFunc, page *Page, rendered *renderedPage, plain bool, stack []string) []byte {
+	content := rendered.content
+	if len(rendered.includes) == 0 {
+		return content
+	}
+	stack = append(stack[:len(stack):len(stack)], page.ShortName())
+
+	for i, include := range rendered.includes {
+		included, err := includePage(rc, lookup, include, plain, stack)
+		if err != nil {
+			if plain {
+				included = nil
+			} else {
+				included = []byte(fmt.Sprintf(`<p class="alert alert-warning">Cannot include %s: %s</p>`,
+					html.EscapeString(include), html.EscapeString(err.Error())))
+			}
+		}
+
+		token := []byte(includeToken(i))
+		if !plain {
+			// the renderers wrap the placeholder in a paragraph
+			content = bytes.Replace(content, []byte("<p>"+string(token)+"</p>"), token, 1)
+		}
+		content = bytes.Replace(content, token, included, 1)
+	}
+	return content
+}
+
+// includePage renders the page found at pagepath for inclusion.
+func includePage(rc *RenderCache, lookup LookupFunc, pagepath string, plain bool, stack []string) ([]byte, error) {
+	for _, p := range stack {
+		if p == pagepath {
+			return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), pagepath)
+		}
+	}
+	if len(stack) > MaxIncludeDepth {
+		return nil, fmt.Errorf("more than %d nested includes", MaxIncludeDepth)
+	}
+	if lookup == nil {
+		return nil, errors.New("includes are not available")
+	}
+
+	page, exists, err := lookup(pagepath)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, errors.New("page not found")
+	} else if page.Header.Encrypted {
+		// encrypted pages would be shown to everyone.
+		return nil, errors.New("encrypted pages cannot be included")
+	}
+
+	var rendered *renderedPage
+	if plain {
+		rendered, err = page.renderParts(page.Content, true)
+	} else {
+		rendered, err = rc.parts(page)
+	}
+	if err != nil {
+		return nil, err
+	}
+	content := renderIncludes(rc, lookup, page, rendered, plain, stack)
+	if !plain {
+		content = rebaseLinks(path.Dir(page.Path), content)
+	}
+	return content, nil
+}
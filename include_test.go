@@ -0,0 +1,130 @@
+package spock
+
+import (
+	"strings"
+	"testing"
+)
+
+func mapLookup(t *testing.T, pages map[string]string) LookupFunc {
+	return func(pagepath string) (*Page, bool, error) {
+		for filename, content := range pages {
+			if ShortenPageName(filename) == pagepath {
+				page := NewPage(filename)
+				checkFatal(t, page.SetRawBytes([]byte(content)))
+				return page, true, nil
+			}
+		}
+		return NewPage(pagepath + ".md"), false, nil
+	}
+}
+
+func TestInclude(t *testing.T) {
+	lookup := mapLookup(t, map[string]string{
+		"shared/oncall.rst":    "On call\n=======\n\n{{include: contacts}}\n",
+		"shared/contacts.org":  "| Name | Phone |\n|------+-------|\n| Bob  | 123   |\n",
+		"shared/disclaimer.md": "*No warranty*",
+		"shared/private.md":    "---\nencrypted: true\n---\nsecret",
+		"loop/a.md":            "A\n\n{{include: b}}\n",
+		"loop/b.md":            "B\n\n{{include: /loop/a}}\n",
+	})
+
+	page := NewPage("notes/index.md")
+	checkFatal(t, page.SetRawBytes([]byte("# Notes\n\n{{include: ../shared/oncall}}\n\n{{include: /shared/disclaimer.md}}\n\n```\n{{include: code}}\n```\n")))
+	html, err := page.Render(lookup)
+	checkFatal(t, err)
+	for _, s := range []string{"<h1>On call</h1>", "<td>Bob</td>", "<em>No warranty</em>", "{{include: code}}"} {
+		if !strings.Contains(string(html), s) {
+			t.Errorf("rendered page should contain %q:\n%s", s, html)
+		}
+	}
+	if strings.Contains(string(html), "placeholder") {
+		t.Errorf("a placeholder was not replaced:\n%s", html)
+	}
+
+	page = NewPage("notes/index.rst")
+	checkFatal(t, page.SetRawBytes([]byte("Notes\n\n{{include: ../shared/oncall}}\n")))
+	txt, err := page.RenderPlaintext(lookup)
+	checkFatal(t, err)
+	if !strings.Contains(string(txt), "On call") || !strings.Contains(string(txt), "Bob") {
+		t.Errorf("the text should contain the included pages: %s", txt)
+	}
+
+	checks := map[string]string{
+		"{{include: /loop/a}}":        "include cycle: index -&gt; loop/a -&gt; loop/b -&gt; loop/a",
+		"{{include: missing}}":        "Cannot include missing: page not found",
+		"{{include: shared/private}}": "encrypted pages cannot be included",
+	}
+	for content, expected := range checks {
+		page := NewPage("index.md")
+		checkFatal(t, page.SetRawBytes([]byte(content)))
+		html, err := page.Render(lookup)
+		checkFatal(t, err)
+		if !strings.Contains(string(html), expected) {
+			t.Errorf("rendering %q should report %q: %s", content, expected, html)
+		}
+	}
+}
+
+func TestIncludeDepth(t *testing.T) {
+	pages := make(map[string]string)
+	for i := 0; i < MaxIncludeDepth+2; i++ {
+		pages[includeToken(i)+".md"] = "{{include: " + includeToken(i+1) + "}}"
+	}
+	page := NewPage("index.md")
+	checkFatal(t, page.SetRawBytes([]byte("{{include: "+includeToken(0)+"}}")))
+	html, err := page.Render(mapLookup(t, pages))
+	checkFatal(t, err)
+	if !strings.Contains(string(html), "nested includes") {
+		t.Fatalf("the include depth should be limited: %s", html)
+	}
+}
+
+func TestIncludeCache(t *testing.T) {
+	rc := NewRenderCache(10)
+	pages := map[string]string{"snippet.md": "version 1"}
+	page := NewPage("index.md")
+	checkFatal(t, page.SetRawBytes([]byte("{{include: snippet}}")))
+
+	_, err := rc.Render(page, mapLookup(t, pages))
+	checkFatal(t, err)
+	// a new version of the included page is shown even if the including
+	// page is cached.
+	pages["snippet.md"] = "version 2"
+	html, err := rc.Render(page, mapLookup(t, pages))
+	checkFatal(t, err)
+	if !strings.Contains(string(html), "version 2") {
+		t.Fatalf("the cached page should include the new version: %s", html)
+	}
+}
+
+func TestIncludeLinks(t *testing.T) {
+	lookup := mapLookup(t, map[string]string{
+		"shared/oncall.md": "[[contacts]] [home](../index) [site](https://example.com) [top](#top) ![logo](logo.png)",
+	})
+
+	page := NewPage("notes/deep/page.md")
+	checkFatal(t, page.SetRawBytes([]byte("[[local]]\n\n{{include: /shared/oncall}}\n")))
+	html, err := page.Render(lookup)
+	checkFatal(t, err)
+	for _, s := range []string{`href="local"`, `href="/shared/contacts"`, `href="/index"`,
+		`href="https://example.com"`, `href="#top"`, `src="/shared/logo.png"`} {
+		if !strings.Contains(string(html), s) {
+			t.Errorf("rendered page should contain %q:\n%s", s, html)
+		}
+	}
+
+	// the included text is indexed with the including page
+	page = NewPage("notes/page.rst")
+	checkFatal(t, page.SetRawBytes([]byte("Own text\n\n{{include: /shared/contacts}}\n")))
+	wikiPage, err := page.ToWikiPage(mapLookup(t, map[string]string{
+		"shared/contacts.rst": "The on call contacts",
+	}))
+	checkFatal(t, err)
+	if !strings.Contains(wikiPage.Body, "Own text") || !strings.Contains(wikiPage.Body, "contacts") ||
+		strings.Contains(wikiPage.Body, "placeholder") {
+		t.Errorf("the text of the page and of the included pages should be indexed: %q", wikiPage.Body)
+	}
+	if strings.Join(wikiPage.Includes, ",") != "shared/contacts" {
+		t.Errorf("the included pages should be [shared/contacts], are %v", wikiPage.Includes)
+	}
+}
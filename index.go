@@ -5,10 +5,14 @@
 package spock
 
 import (
+	"bytes"
+	"encoding/json"
 	"github.com/blevesearch/bleve"
 	bleveDocument "github.com/blevesearch/bleve/document"
 	"log"
+	"os"
 	"path/filepath"
+	"sort"
 	"time"
 )
 
@@ -16,17 +20,43 @@ const (
 	// IndexDirName is the name of the directory containing the bleve index
 	IndexDirName = ".bleve"
 
-	textAnalyzer   = "standard"
-	textEnAnalyzer = "en"
-	textItAnalyzer = "it"
+	textAnalyzer    = "standard"
+	keywordAnalyzer = "keyword"
+
+	// internal key of the mapping version and of the search languages the
+	// index was built with.
+	mappingKey = "spock:mapping"
+	// version of the index mapping, increased when a field is added.
+	mappingVersion = 2
+
+	// maximum number of terms returned by Tags and Aliases.
+	maxTags = 10000
 )
 
+// DefaultSearchLanguages maps the languages of the pages to the bleve
+// analyzers of their text, when the configuration doesn't specify them.
+var DefaultSearchLanguages = map[string]string{
+	"en": "en",
+	"it": "it",
+}
+
+// WikiPage is the document indexing a page; the body is indexed in the field
+// of its language (see bodyField).
 type WikiPage struct {
-	Title  string    `json:"title"`
-	BodyEn string    `json:"body_en"`
-	BodyIt string    `json:"body_it"`
-	Body   string    `json:"body"`
-	Mtime  time.Time `json:"mtime"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Language    string    `json:"language"`
+	Body        string    `json:"body"`
+	Mtime       time.Time `json:"mtime"`
+	Tags        []string  `json:"tags"`
+	Aliases     []string  `json:"aliases"`
+	// path of the pages included in the body, at any depth.
+	Includes []string               `json:"includes"`
+	Owner    string                 `json:"owner"`
+	Draft    bool                   `json:"draft"`
+	Created  time.Time              `json:"created"`
+	Updated  time.Time              `json:"updated"`
+	Custom   map[string]interface{} `json:"custom"`
 }
 
 func (wp *WikiPage) Type() string {
@@ -36,28 +66,47 @@ func (wp *WikiPage) Type() string {
 type Index struct {
 	index bleve.Index
 	path  string
+	// analyzers of the indexed languages, by language.
+	languages map[string]string
 }
 
-func buildIndexMapping() *bleve.IndexMapping {
-
-	enTextMapping := bleve.NewTextFieldMapping()
-	enTextMapping.Analyzer = textEnAnalyzer
-
-	itTextMapping := bleve.NewTextFieldMapping()
-	itTextMapping.Analyzer = textItAnalyzer
+// bodyField returns the name of the field containing the body of the pages
+// written in language; "body" is used for the pages without a language.
+func bodyField(language string) string {
+	if language == "" {
+		return "body"
+	}
+	return "body_" + language
+}
 
+func buildIndexMapping(languages map[string]string) *bleve.IndexMapping {
 	stdTextMapping := bleve.NewTextFieldMapping()
 	stdTextMapping.Analyzer = textAnalyzer
 
+	// tags, aliases, includes and owners are matched as a whole.
+	keywordMapping := bleve.NewTextFieldMapping()
+	keywordMapping.Analyzer = keywordAnalyzer
+
 	dtMapping := bleve.NewDateTimeFieldMapping()
 
 	wikiPageMapping := bleve.NewDocumentMapping()
 	wikiPageMapping.AddFieldMappingsAt("title", stdTextMapping)
 	wikiPageMapping.AddSubDocumentMapping("id", bleve.NewDocumentDisabledMapping())
-	wikiPageMapping.AddFieldMappingsAt("body_en", enTextMapping)
-	wikiPageMapping.AddFieldMappingsAt("body_it", itTextMapping)
-	wikiPageMapping.AddFieldMappingsAt("body", stdTextMapping)
+	wikiPageMapping.AddFieldMappingsAt("description", stdTextMapping)
+	wikiPageMapping.AddFieldMappingsAt(bodyField(""), stdTextMapping)
+	for language, analyzer := range languages {
+		textMapping := bleve.NewTextFieldMapping()
+		textMapping.Analyzer = analyzer
+		wikiPageMapping.AddFieldMappingsAt(bodyField(language), textMapping)
+	}
 	wikiPageMapping.AddFieldMappingsAt("mtime", dtMapping)
+	wikiPageMapping.AddFieldMappingsAt("tags", keywordMapping)
+	wikiPageMapping.AddFieldMappingsAt("aliases", keywordMapping)
+	wikiPageMapping.AddFieldMappingsAt("includes", keywordMapping)
+	wikiPageMapping.AddFieldMappingsAt("owner", keywordMapping)
+	wikiPageMapping.AddFieldMappingsAt("draft", bleve.NewBooleanFieldMapping())
+	wikiPageMapping.AddFieldMappingsAt("created", dtMapping)
+	wikiPageMapping.AddFieldMappingsAt("updated", dtMapping)
 
 	mapping := bleve.NewIndexMapping()
 	mapping.AddDocumentMapping("wikiPage", wikiPageMapping)
@@ -67,30 +116,137 @@ func buildIndexMapping() *bleve.IndexMapping {
 	return mapping
 }
 
-func OpenIndex(basepath string) (*Index, error) {
+// OpenIndex opens the search index of the wiki found in basepath, creating
+// it when missing; languages maps the languages of the pages to the bleve
+// analyzers of their text. The index is rebuilt, empty, when it was built
+// with different languages or with an older mapping: rebuilt is true when
+// that happens, and the caller must index the wiki again.
+func OpenIndex(basepath string, languages map[string]string) (*Index, bool, error) {
 	path := filepath.Join(basepath, IndexDirName)
+	if len(languages) == 0 {
+		languages = DefaultSearchLanguages
+	}
+	// encoding/json sorts the keys of the maps.
+	mappingData, err := json.Marshal(map[string]interface{}{
+		"version":   mappingVersion,
+		"languages": languages,
+	})
+	if err != nil {
+		return nil, false, err
+	}
 
+	rebuilt := false
 	index, err := bleve.Open(path)
+	if err == nil {
+		var current []byte
+		if current, err = index.GetInternal([]byte(mappingKey)); err != nil {
+			index.Close()
+			return nil, false, err
+		} else if !bytes.Equal(current, mappingData) {
+			log.Println("The search languages or the index mapping changed: rebuilding the search index")
+			index.Close()
+			if err = os.RemoveAll(path); err != nil {
+				return nil, false, err
+			}
+			err = bleve.ErrorIndexPathDoesNotExist
+			rebuilt = true
+		}
+	}
+
 	if err == bleve.ErrorIndexPathDoesNotExist {
 		log.Println("Creating a new search index")
-		indexMapping := buildIndexMapping()
+		indexMapping := buildIndexMapping(languages)
 		index, err = bleve.New(path, indexMapping)
 		if err != nil {
-			return nil, err
+			return nil, false, err
+		}
+		if err = index.SetInternal([]byte(mappingKey), mappingData); err != nil {
+			index.Close()
+			return nil, false, err
 		}
 	} else if err != nil {
+		return nil, false, err
+	}
+
+	return &Index{index: index, path: path, languages: languages}, rebuilt, nil
+}
+
+// document returns the document indexed for wp, with the body in the field
+// of its language.
+func (idx *Index) document(wp *WikiPage) map[string]interface{} {
+	doc := map[string]interface{}{
+		"_type":       wp.Type(),
+		"title":       wp.Title,
+		"description": wp.Description,
+		"mtime":       wp.Mtime,
+		"tags":        wp.Tags,
+		"aliases":     wp.Aliases,
+		"includes":    wp.Includes,
+		"owner":       wp.Owner,
+		"draft":       wp.Draft,
+		"created":     wp.Created,
+		"updated":     wp.Updated,
+		"custom":      wp.Custom,
+	}
+	language := wp.Language
+	if _, ok := idx.languages[language]; !ok {
+		language = ""
+	}
+	doc[bodyField(language)] = wp.Body
+	return doc
+}
+
+// AddPage indexes page; the included pages are found with lookup.
+func (idx *Index) AddPage(page *Page, lookup LookupFunc) error {
+	wikiPage, err := page.ToWikiPage(lookup)
+	if err != nil {
+		return err
+	}
+	return idx.index.Index(page.ShortName(), idx.document(wikiPage))
+}
+
+// Includers returns the path of the pages including pagepath, directly or
+// through other pages.
+func (idx *Index) Includers(pagepath string) ([]string, error) {
+	count, err := idx.index.DocCount()
+	if err != nil || count == 0 {
+		return nil, err
+	}
+
+	query := bleve.NewTermQuery(ShortenPageName(cleanTreePath(pagepath))).SetField("includes")
+	req := bleve.NewSearchRequestOptions(query, int(count), 0, false)
+	res, err := idx.index.Search(req)
+	if err != nil {
 		return nil, err
 	}
 
-	return &Index{index: index, path: path}, nil
+	var result []string
+	for _, hit := range res.Hits {
+		result = append(result, hit.ID)
+	}
+	sort.Strings(result)
+	return result, nil
 }
 
-func (idx *Index) AddPage(page *Page) error {
-	wikiPage, err := page.ToWikiPage()
+// ReindexIncluders indexes again the pages including pagepath, whose text
+// contains the one of pagepath; it must be called after pagepath changes.
+func (idx *Index) ReindexIncluders(pagepath string, lookup LookupFunc) error {
+	includers, err := idx.Includers(pagepath)
 	if err != nil {
 		return err
 	}
-	return idx.index.Index(page.ShortName(), wikiPage)
+	for _, includer := range includers {
+		page, exists, err := lookup(includer)
+		if err != nil {
+			return err
+		} else if !exists {
+			continue
+		}
+		if err = idx.AddPage(page, lookup); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (idx *Index) DeletePage(page *Page) error {
@@ -105,6 +261,29 @@ func (idx *Index) DocCount() (uint64, error) {
 	return idx.index.DocCount()
 }
 
+// DocumentIDs returns the id of every document in the index.
+func (idx *Index) DocumentIDs() ([]string, error) {
+	count, err := idx.index.DocCount()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	if count == 0 {
+		return result, nil
+	}
+
+	req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), int(count), 0, false)
+	res, err := idx.index.Search(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, hit := range res.Hits {
+		result = append(result, hit.ID)
+	}
+	return result, nil
+}
+
 func (idx *Index) IndexWiki(storage Storage) error {
 	pages, err := storage.ListPages()
 	if err != nil {
@@ -143,13 +322,13 @@ func (idx *Index) IndexWiki(storage Storage) error {
 			}
 		}
 
-		wikiPage, err := page.ToWikiPage()
+		wikiPage, err := page.ToWikiPage(storage.LookupPage)
 		if err != nil {
 			log.Printf("Error converting page %s for indexing: %s\n", page.ShortName(), err)
 			continue
 		}
 
-		batch.Index(page.ShortName(), wikiPage)
+		batch.Index(page.ShortName(), idx.document(wikiPage))
 	}
 
 	err = idx.index.Batch(batch)
@@ -160,31 +339,188 @@ func (idx *Index) IndexWiki(storage Storage) error {
 	return err
 }
 
-func (page *Page) ToWikiPage() (*WikiPage, error) {
-	text, err := page.RenderPlaintext()
+// ToWikiPage returns the document indexing page, including the text of the
+// pages it includes, which are found with lookup.
+func (page *Page) ToWikiPage(lookup LookupFunc) (*WikiPage, error) {
+	var body string
+	var includes []string
+	// only the title of an encrypted page is indexed.
+	if !page.Header.Encrypted {
+		var recording LookupFunc
+		if lookup != nil {
+			recording = func(pagepath string) (*Page, bool, error) {
+				includes = append(includes, pagepath)
+				return lookup(pagepath)
+			}
+		}
+		text, err := page.RenderPlaintext(recording)
+		if err != nil {
+			return nil, err
+		}
+		body = string(text)
+	}
+	wp := &WikiPage{
+		Title:       page.ShortName(),
+		Description: page.Header.Description,
+		Language:    page.Header.Language,
+		Body:        body,
+		Mtime:       page.Mtime,
+		Tags:        page.Header.Tags,
+		Aliases:     aliasPaths(page.Header),
+		Includes:    includes,
+		Owner:       page.Header.Owner,
+		Draft:       page.Header.Draft,
+		Created:     page.Header.CreatedTime(),
+		Updated:     page.Header.UpdatedTime(),
+		Custom:      page.Header.Custom,
+	}
+	return wp, nil
+}
+
+// TagCount is a tag and the number of pages tagged with it.
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// Tags returns every tag found in the index with its page count, sorted by
+// name.
+func (idx *Index) Tags() ([]TagCount, error) {
+	count, err := idx.index.DocCount()
 	if err != nil {
 		return nil, err
 	}
-	body := string(text)
-	wp := &WikiPage{Title: page.ShortName(), Mtime: page.Mtime}
 
-	if page.Header.Language == "it" {
-		wp.BodyIt = body
-	} else if page.Header.Language == "en" {
-		wp.BodyEn = body
-	} else {
-		wp.Body = body
+	var result []TagCount
+	if count == 0 {
+		return result, nil
 	}
-	return wp, nil
+
+	req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), 0, 0, false)
+	req.AddFacet("tags", bleve.NewFacetRequest("tags", maxTags))
+	res, err := idx.index.Search(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if facet, ok := res.Facets["tags"]; ok {
+		for _, term := range facet.Terms {
+			result = append(result, TagCount{Tag: term.Term, Count: term.Count})
+		}
+	}
+	sort.Sort(tagCountsByName(result))
+	return result, nil
+}
+
+type tagCountsByName []TagCount
+
+func (t tagCountsByName) Len() int           { return len(t) }
+func (t tagCountsByName) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t tagCountsByName) Less(i, j int) bool { return t[i].Tag < t[j].Tag }
+
+// TaggedPage is a page found by PagesWithTag.
+type TaggedPage struct {
+	Path        string
+	Description string
+}
+
+// PagesWithTag returns the pages tagged with tag, sorted by path.
+func (idx *Index) PagesWithTag(tag string) ([]TaggedPage, error) {
+	count, err := idx.index.DocCount()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []TaggedPage
+	if count == 0 {
+		return result, nil
+	}
+
+	query := bleve.NewTermQuery(tag).SetField("tags")
+	req := bleve.NewSearchRequestOptions(query, int(count), 0, false)
+	req.Fields = []string{"description"}
+	res, err := idx.index.Search(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hit := range res.Hits {
+		tp := TaggedPage{Path: hit.ID}
+		if description, ok := hit.Fields["description"].(string); ok {
+			tp.Description = description
+		}
+		result = append(result, tp)
+	}
+	sort.Sort(taggedPagesByPath(result))
+	return result, nil
+}
+
+type taggedPagesByPath []TaggedPage
+
+func (t taggedPagesByPath) Len() int           { return len(t) }
+func (t taggedPagesByPath) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t taggedPagesByPath) Less(i, j int) bool { return t[i].Path < t[j].Path }
+
+// Aliases returns the aliases of every page found in the index.
+func (idx *Index) Aliases() ([]string, error) {
+	req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), 0, 0, false)
+	req.AddFacet("aliases", bleve.NewFacetRequest("aliases", maxTags))
+	res, err := idx.index.Search(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	if facet, ok := res.Facets["aliases"]; ok {
+		for _, term := range facet.Terms {
+			result = append(result, term.Term)
+		}
+	}
+	return result, nil
+}
+
+// ResolveAlias returns the path of the page having the alias pagepath; when
+// more pages share the same alias the first one, by path, wins.
+func (idx *Index) ResolveAlias(pagepath string) (string, bool, error) {
+	query := bleve.NewTermQuery(cleanTreePath(pagepath)).SetField("aliases")
+	req := bleve.NewSearchRequestOptions(query, maxTags, 0, false)
+	res, err := idx.index.Search(req)
+	if err != nil {
+		return "", false, err
+	} else if len(res.Hits) == 0 {
+		return "", false, nil
+	}
+
+	result := res.Hits[0].ID
+	for _, hit := range res.Hits[1:] {
+		if hit.ID < result {
+			result = hit.ID
+		}
+	}
+	return result, true, nil
+}
+
+// searchFields returns the fields matched by a search: the title and the
+// body fields of every language.
+func (idx *Index) searchFields() []string {
+	fields := []string{"title", bodyField("")}
+	var languages []string
+	for language := range idx.languages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	for _, language := range languages {
+		fields = append(fields, bodyField(language))
+	}
+	return fields
 }
 
 func (ac *AppContext) Search(searchQuery string, size, from int) (*bleve.SearchResult, error) {
-	// query := bleve.NewQueryStringQuery(searchQuery)
-	queryEn := bleve.NewMatchQuery(searchQuery).SetField("body_en")
-	queryIt := bleve.NewMatchQuery(searchQuery).SetField("body_it")
-	queryStd := bleve.NewMatchQuery(searchQuery).SetField("body")
-	queryTitle := bleve.NewMatchQuery(searchQuery).SetField("title")
-	query := bleve.NewDisjunctionQuery([]bleve.Query{queryEn, queryIt, queryStd, queryTitle})
+	var queries []bleve.Query
+	for _, field := range ac.Index.searchFields() {
+		queries = append(queries, bleve.NewMatchQuery(searchQuery).SetField(field))
+	}
+	query := bleve.NewDisjunctionQuery(queries)
 
 	req := bleve.NewSearchRequestOptions(query, 100, 0, false)
 	req.Highlight = bleve.NewHighlight()
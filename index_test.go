@@ -0,0 +1,30 @@
+package spock
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndexDocument(t *testing.T) {
+	idx := &Index{languages: map[string]string{"de": "de", "fr": "fr"}}
+
+	expected := []string{"title", "body", "body_de", "body_fr"}
+	if fields := idx.searchFields(); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected %v, got %v", expected, fields)
+	}
+
+	checks := map[string]string{
+		"de": "body_de",
+		"it": "body",
+		"":   "body",
+	}
+	for language, field := range checks {
+		doc := idx.document(&WikiPage{Title: "page", Language: language, Body: "text"})
+		if doc[field] != "text" {
+			t.Errorf("the body of a %q page should be in %s: %v", language, field, doc)
+		}
+		if doc["_type"] != "wikiPage" {
+			t.Errorf("unexpected document type %v", doc["_type"])
+		}
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// A Storage made of several storages mounted under path prefixes.
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+type mount struct {
+	prefix  string
+	storage Storage
+}
+
+// MountStorage dispatches every operation to the storage mounted on the
+// longest prefix of the page path; the pages outside of every mount belong
+// to the root storage. Branches belong to the root storage: on a branch the
+// mounted pages are the current ones.
+type MountStorage struct {
+	root   Storage
+	mounts []mount
+}
+
+// NewMountStorage creates a MountStorage without mounts.
+func NewMountStorage(root Storage) *MountStorage {
+	return &MountStorage{root: root}
+}
+
+// Mount attaches storage to the directory prefix (e.g. "runbooks").
+func (ms *MountStorage) Mount(prefix string, storage Storage) error {
+	prefix = cleanTreePath(prefix)
+	if prefix == "" {
+		return errors.New("Cannot mount a storage on the root of the wiki")
+	}
+	for _, m := range ms.mounts {
+		if m.prefix == prefix {
+			return fmt.Errorf("A storage is already mounted on %s", prefix)
+		}
+	}
+
+	ms.mounts = append(ms.mounts, mount{prefix, storage})
+	// the longest prefixes must be tried first.
+	sort.Sort(mountsByPrefix(ms.mounts))
+	return nil
+}
+
+type mountsByPrefix []mount
+
+func (m mountsByPrefix) Len() int           { return len(m) }
+func (m mountsByPrefix) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m mountsByPrefix) Less(i, j int) bool { return len(m[i].prefix) > len(m[j].prefix) }
+
+// resolve returns the storage holding the file or page "p", its mount
+// prefix and the path relative to the storage.
+func (ms *MountStorage) resolve(p string) (Storage, string, string) {
+	p = cleanTreePath(p)
+	for _, m := range ms.mounts {
+		if strings.HasPrefix(p, m.prefix+"/") {
+			return m.storage, m.prefix, p[len(m.prefix)+1:]
+		}
+	}
+	return ms.root, "", p
+}
+
+// resolveDir is like resolve but also matches the mount directories.
+func (ms *MountStorage) resolveDir(dir string) (Storage, string, string) {
+	dir = cleanTreePath(dir)
+	for _, m := range ms.mounts {
+		if dir == m.prefix {
+			return m.storage, m.prefix, ""
+		}
+	}
+	return ms.resolve(dir)
+}
+
+// isMounted returns true when the root storage path p is hidden by a mount.
+func (ms *MountStorage) isMounted(p string) bool {
+	for _, m := range ms.mounts {
+		if p == m.prefix || strings.HasPrefix(p, m.prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func joinMount(prefix, p string) string {
+	if prefix == "" {
+		return p
+	}
+	return path.Join(prefix, p)
+}
+
+func (ms *MountStorage) ReadFile(relpath string) ([]byte, time.Time, error) {
+	storage, _, rel := ms.resolve(relpath)
+	return storage.ReadFile(rel)
+}
+
+func (ms *MountStorage) LookupPage(pagepath string) (*Page, bool, error) {
+	storage, prefix, rel := ms.resolve(pagepath)
+	page, exists, err := storage.LookupPage(rel)
+	if page != nil {
+		page.Path = joinMount(prefix, page.Path)
+	}
+	return page, exists, err
+}
+
+// LookupPageAt fetches a page from a revision; since the branches belong to
+// the root storage, the mounted pages of a branch are the current ones.
+func (ms *MountStorage) LookupPageAt(rev, pagepath string) (*Page, bool, error) {
+	storage, prefix, rel := ms.resolve(pagepath)
+	var page *Page
+	var exists bool
+	var err error
+	if prefix != "" && !revisionRe.MatchString(rev) {
+		page, exists, err = storage.LookupPage(rel)
+	} else {
+		page, exists, err = storage.LookupPageAt(rev, rel)
+	}
+	if page != nil {
+		page.Path = joinMount(prefix, page.Path)
+	}
+	return page, exists, err
+}
+
+func (ms *MountStorage) RenamePage(origPath, destPath string, signature *CommitSignature, message string) (RevID, error) {
+	storage, prefix, origRel := ms.resolve(origPath)
+	destStorage, destPrefix, destRel := ms.resolve(destPath)
+	if storage != destStorage || prefix != destPrefix {
+		return "", errors.New("Cannot move a page to another repository")
+	}
+	return storage.RenamePage(origRel, destRel, signature, message)
+}
+
+func (ms *MountStorage) DeletePage(path string, signature *CommitSignature, message string) (RevID, error) {
+	storage, _, rel := ms.resolve(path)
+	return storage.DeletePage(rel, signature, message)
+}
+
+func (ms *MountStorage) SavePage(page *Page, sig *CommitSignature, message string) (RevID, error) {
+	storage, _, rel := ms.resolve(page.Path)
+	mounted := *page
+	mounted.Path = rel
+	return storage.SavePage(&mounted, sig, message)
+}
+
+func (ms *MountStorage) LogsForPage(path string) ([]CommitLog, error) {
+	storage, _, rel := ms.resolve(path)
+	return storage.LogsForPage(rel)
+}
+
+func (ms *MountStorage) GetLastCommit(path string) (*CommitLog, error) {
+	storage, _, rel := ms.resolve(path)
+	return storage.GetLastCommit(rel)
+}
+
+func (ms *MountStorage) ListPages() ([]string, error) {
+	pages, err := ms.root.ListPages()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, page := range pages {
+		if !ms.isMounted(page) {
+			result = append(result, page)
+		}
+	}
+	for _, m := range ms.mounts {
+		pages, err := m.storage.ListPages()
+		if err != nil {
+			return nil, err
+		}
+		for _, page := range pages {
+			result = append(result, joinMount(m.prefix, page))
+		}
+	}
+	return result, nil
+}
+
+// ListPagesAt lists the pages of a revision of the root storage; like in
+// LookupPageAt, the mounted pages of a branch are the current ones.
+func (ms *MountStorage) ListPagesAt(rev string) ([]string, error) {
+	pages, err := ms.root.ListPagesAt(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, page := range pages {
+		if !ms.isMounted(page) {
+			result = append(result, page)
+		}
+	}
+	if revisionRe.MatchString(rev) {
+		return result, nil
+	}
+	for _, m := range ms.mounts {
+		pages, err := m.storage.ListPages()
+		if err != nil {
+			return nil, err
+		}
+		for _, page := range pages {
+			result = append(result, joinMount(m.prefix, page))
+		}
+	}
+	return result, nil
+}
+
+func (ms *MountStorage) DiffPage(page *Page, revA, revB string) ([]string, error) {
+	storage, _, rel := ms.resolve(page.Path)
+	mounted := *page
+	mounted.Path = rel
+	return storage.DiffPage(&mounted, revA, revB)
+}
+
+// WalkFiles walks the files of the storage holding dir; walking the root of
+// the wiki at the last commit also walks every mount.
+func (ms *MountStorage) WalkFiles(rev, dir string, fn WalkFilesFunc) error {
+	storage, _, rel := ms.resolveDir(dir)
+	if storage != ms.root || rev != "" || rel != "" {
+		return storage.WalkFiles(rev, rel, fn)
+	}
+
+	err := ms.root.WalkFiles("", "", func(filename string, data []byte) error {
+		if ms.isMounted(filename) {
+			return nil
+		}
+		return fn(filename, data)
+	})
+	if err != nil {
+		return err
+	}
+	for _, m := range ms.mounts {
+		err = m.storage.WalkFiles("", "", func(filename string, data []byte) error {
+			return fn(joinMount(m.prefix, filename), data)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ms *MountStorage) DeletedPages() ([]DeletedPage, error) {
+	var result []DeletedPage
+
+	pages, err := ms.root.DeletedPages()
+	if err != nil {
+		return nil, err
+	}
+	for _, page := range pages {
+		if !ms.isMounted(page.Path) {
+			result = append(result, page)
+		}
+	}
+	for _, m := range ms.mounts {
+		pages, err := m.storage.DeletedPages()
+		if err != nil {
+			return nil, err
+		}
+		for _, page := range pages {
+			page.Path = joinMount(m.prefix, page.Path)
+			result = append(result, page)
+		}
+	}
+
+	sort.Stable(deletedPagesByTime(result))
+	return result, nil
+}
+
+// deletedPagesByTime sorts the deleted pages, most recent first.
+type deletedPagesByTime []DeletedPage
+
+func (d deletedPagesByTime) Len() int           { return len(d) }
+func (d deletedPagesByTime) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d deletedPagesByTime) Less(i, j int) bool { return d[i].Commit.When.After(d[j].Commit.When) }
+
+func (ms *MountStorage) RestorePage(path, rev string, signature *CommitSignature, message string) (RevID, error) {
+	storage, _, rel := ms.resolve(path)
+	return storage.RestorePage(rel, rev, signature, message)
+}
+
+func (ms *MountStorage) RevertPage(path, rev string, signature *CommitSignature, message string) (RevID, error) {
+	storage, _, rel := ms.resolve(path)
+	return storage.RevertPage(rel, rev, signature, message)
+}
+
+// CommitDiff looks for the commit in every storage.
+func (ms *MountStorage) CommitDiff(rev RevID) (string, error) {
+	diff, err := ms.root.CommitDiff(rev)
+	if err == nil {
+		return diff, nil
+	}
+	for _, m := range ms.mounts {
+		if diff, err := m.storage.CommitDiff(rev); err == nil {
+			return diff, nil
+		}
+	}
+	return "", err
+}
+
+func (ms *MountStorage) CurrentBranch() (string, error) {
+	return ms.root.CurrentBranch()
+}
+
+func (ms *MountStorage) Branches() ([]string, error) {
+	return ms.root.Branches()
+}
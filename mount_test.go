@@ -0,0 +1,107 @@
+package spock
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMountStorage(t *testing.T) {
+	root := createTestRepo(t)
+	defer cleanup(t, root)
+	runbooks := createTestRepo(t)
+	defer cleanup(t, runbooks)
+
+	createTestPage(t, root, "index.md", "this is my index", "test user", "test@email.com", "created", time.Now())
+	checkFatal(t, MkMissingDirs(root.MakeAbsPath("runbooks/hidden.md")))
+	createTestPage(t, root, "runbooks/hidden.md", "hidden by the mount", "test user", "test@email.com", "created", time.Now())
+	createTestPage(t, runbooks, "deploy.md", "how to deploy", "test user", "test@email.com", "created", time.Now())
+
+	ms := NewMountStorage(root)
+	checkFatal(t, ms.Mount("/runbooks/", runbooks))
+	if err := ms.Mount("runbooks", runbooks); err == nil {
+		t.Fatal("Mounting twice on the same prefix should fail")
+	}
+
+	pages, err := ms.ListPages()
+	checkFatal(t, err)
+	if strings.Join(pages, ",") != "index,runbooks/deploy" {
+		t.Fatalf("Pages should be [index runbooks/deploy], are %v", pages)
+	}
+
+	page, exists, err := ms.LookupPage("runbooks/deploy")
+	checkFatal(t, err)
+	if !exists || page.Path != "runbooks/deploy.md" || string(page.RawBytes) != "how to deploy" {
+		t.Fatalf("runbooks/deploy should come from the mounted repository: %+v", page)
+	}
+
+	sig := createSignature(t)
+	page, _, err = ms.LookupPage("runbooks/restart")
+	checkFatal(t, err)
+	checkFatal(t, page.SetRawBytes([]byte("how to restart")))
+	_, err = ms.SavePage(page, sig, "add restart")
+	checkFatal(t, err)
+	if page.Path != "runbooks/restart.md" {
+		t.Fatalf("SavePage should not modify the page path, is %s", page.Path)
+	}
+
+	mounted, err := runbooks.ListPages()
+	checkFatal(t, err)
+	if strings.Join(mounted, ",") != "deploy,restart" {
+		t.Fatalf("The mounted repository should contain [deploy restart], contains %v", mounted)
+	}
+
+	logs, err := ms.LogsForPage("runbooks/restart.md")
+	checkFatal(t, err)
+	if len(logs) != 1 || logs[0].Message != "add restart" {
+		t.Fatalf("Unexpected history of runbooks/restart.md: %+v", logs)
+	}
+
+	if _, err = ms.RenamePage("runbooks/restart.md", "restart.md", sig, "move"); err == nil {
+		t.Fatal("Moving a page to another repository should fail")
+	}
+
+	var files []string
+	checkFatal(t, ms.WalkFiles("", "runbooks", func(filename string, data []byte) error {
+		files = append(files, filename)
+		return nil
+	}))
+	if strings.Join(files, ",") != "deploy.md,restart.md" {
+		t.Fatalf("The runbooks directory should contain [deploy.md restart.md], contains %v", files)
+	}
+}
+
+func TestMountStorageBranch(t *testing.T) {
+	root := createTestRepo(t)
+	defer cleanup(t, root)
+	runbooks := createTestRepo(t)
+	defer cleanup(t, runbooks)
+
+	createTestPage(t, root, "index.md", "old index", "test user", "test@email.com", "created", time.Now())
+	commit, _, err := root.currentState()
+	checkFatal(t, err)
+	_, err = root.r.References.Create("refs/heads/draft", commit.Id(), false, "create draft")
+	checkFatal(t, err)
+	createTestPage(t, runbooks, "deploy.md", "how to deploy", "test user", "test@email.com", "created", time.Now())
+
+	ms := NewMountStorage(root)
+	checkFatal(t, ms.Mount("runbooks", runbooks))
+
+	page, exists, err := ms.LookupPageAt("draft", "index")
+	checkFatal(t, err)
+	if !exists || string(page.RawBytes) != "old index" {
+		t.Fatalf("index on the draft branch should contain \"old index\", is %+v", page)
+	}
+
+	// the mounted repository has no draft branch
+	page, exists, err = ms.LookupPageAt("draft", "runbooks/deploy")
+	checkFatal(t, err)
+	if !exists || page.Path != "runbooks/deploy.md" || string(page.RawBytes) != "how to deploy" {
+		t.Fatalf("runbooks/deploy on the draft branch should be the current page: %+v", page)
+	}
+	pages, err := ms.ListPagesAt("draft")
+	checkFatal(t, err)
+	if strings.Join(pages, ",") != "index,runbooks/deploy" {
+		t.Fatalf("Pages on the draft branch should be [index runbooks/deploy], are %v", pages)
+	}
+}
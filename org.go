@@ -0,0 +1,732 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// An Org-mode renderer for the elements commonly used in wiki pages:
+// headlines with TODO keywords, priorities and tags, property drawers,
+// plain, ordered, description and check lists, tables, source, example and
+// quote blocks, fixed width lines, horizontal rules, emphasis markers and
+// links.
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+type orgKind int
+
+const (
+	orgHeadline orgKind = iota
+	orgParagraph
+	orgProperties
+	orgList
+	orgItem
+	orgTable
+	orgSrc
+	orgExample
+	orgQuote
+	orgRule
+)
+
+// orgNode is a block of an Org document.
+type orgNode struct {
+	kind orgKind
+	// inline text of headlines and paragraphs, content of source and
+	// example blocks.
+	text string
+	// headline level, starting from 1.
+	level int
+	// headline TODO keyword, priority and tags.
+	keyword  string
+	priority string
+	tags     []string
+	// language of source blocks.
+	lang string
+	// list type: "-" for plain lists, "1" for ordered lists, "::" for
+	// description lists.
+	list string
+	// item checkbox (" ", "X" or "-") and description list term.
+	checkbox string
+	term     string
+	// list items, quote and item content.
+	children []*orgNode
+	// table cells and property drawer entries; the first "header" rows of
+	// a table are the table header.
+	rows   [][]string
+	header int
+}
+
+// orgDocument holds the settings of an Org document.
+type orgDocument struct {
+	// TODO keywords, mapped to "todo" or "done".
+	keywords map[string]string
+}
+
+var (
+	orgHeadlineRe = regexp.MustCompile(`^(\*+)\s+(.*?)\s*$`)
+	orgTagsRe     = regexp.MustCompile(`^(.*?)\s+(:[\w@#%:]+:)$`)
+	orgPriorityRe = regexp.MustCompile(`^\[#([A-Za-z0-9])\]\s*(.*)$`)
+	orgTodoRe     = regexp.MustCompile(`^#\+(?:SEQ_|TYP_)?TODO:\s*(.*)$`)
+	orgKeywordRe  = regexp.MustCompile(`^#\+\w+:`)
+	orgBeginRe    = regexp.MustCompile(`(?i)^#\+BEGIN_(\w+)\s*(.*)$`)
+	orgDrawerRe   = regexp.MustCompile(`^:([\w-]+):\s*$`)
+	orgPropertyRe = regexp.MustCompile(`^:([^:\s]+):\s*(.*)$`)
+	orgItemRe     = regexp.MustCompile(`^([-+]|\d+[.)]|[*])(\s+(.*)|$)`)
+	orgCheckboxRe = regexp.MustCompile(`^\[([ Xx-])\]\s*(.*)$`)
+	orgRuleRe     = regexp.MustCompile(`^-{5,}$`)
+	orgLinkRe     = regexp.MustCompile(`^\[\[([^\]]+)\](?:\[([^\]]+)\])?\]`)
+	orgURLRe      = regexp.MustCompile(`^https?://[^\s<>"\]]*[^\s<>".,;:!?)'\]]`)
+	orgImageRe    = regexp.MustCompile(`(?i)\.(png|jpe?g|gif|svg)$`)
+)
+
+// renderOrgNative renders an Org document as HTML.
+func renderOrgNative(content []byte) ([]byte, error) {
+	doc, nodes := parseOrg(content)
+	var buf bytes.Buffer
+	doc.writeHTML(&buf, nodes)
+	return buf.Bytes(), nil
+}
+
+// renderOrgNativePlain returns the text of an Org document, without the
+// markup.
+func renderOrgNativePlain(content []byte) ([]byte, error) {
+	doc, nodes := parseOrg(content)
+	var buf bytes.Buffer
+	doc.writeText(&buf, nodes)
+	return buf.Bytes(), nil
+}
+
+func parseOrg(content []byte) (*orgDocument, []*orgNode) {
+	lines := splitLines(string(content))
+	doc := &orgDocument{keywords: make(map[string]string)}
+	for _, line := range lines {
+		if m := orgTodoRe.FindStringSubmatch(line); m != nil {
+			doc.setKeywords(m[1])
+		}
+	}
+	// "#+TODO:" lines replace the default keywords
+	if len(doc.keywords) == 0 {
+		doc.keywords["TODO"] = "todo"
+		doc.keywords["DONE"] = "done"
+	}
+	return doc, doc.parseBlocks(lines)
+}
+
+// setKeywords parses a "#+TODO:" line; the keywords after "|" (or the last
+// one, without "|") mark the completed tasks.
+func (doc *orgDocument) setKeywords(spec string) {
+	fields := strings.Fields(spec)
+	done := false
+	for i, field := range fields {
+		if field == "|" {
+			done = true
+			continue
+		}
+		// keywords may define a fast access key, e.g. "WAIT(w)"
+		if n := strings.Index(field, "("); n > 0 {
+			field = field[:n]
+		}
+		if done || (i == len(fields)-1 && !strings.Contains(spec, "|")) {
+			doc.keywords[field] = "done"
+		} else {
+			doc.keywords[field] = "todo"
+		}
+	}
+}
+
+// orgListItem returns the list type and the text of a list item, and
+// whether line is a list item.
+func orgListItem(line string) (string, string, bool) {
+	m := orgItemRe.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", false
+	}
+	kind := "-"
+	if m[1][0] >= '0' && m[1][0] <= '9' {
+		kind = "1"
+	}
+	return kind, m[3], true
+}
+
+// startsBlock returns true if the line at column 0 starts an element other
+// than a paragraph.
+func (doc *orgDocument) startsBlock(line string) bool {
+	if indentOf(line) == 0 && orgHeadlineRe.MatchString(line) {
+		return true
+	}
+	trimmed := strings.TrimLeft(line, " ")
+	if _, _, ok := orgListItem(trimmed); ok {
+		return true
+	}
+	return strings.HasPrefix(trimmed, "|") || strings.HasPrefix(trimmed, "#+") ||
+		trimmed == ":" || strings.HasPrefix(trimmed, ": ") || orgRuleRe.MatchString(trimmed) ||
+		orgDrawerRe.MatchString(trimmed)
+}
+
+func (doc *orgDocument) parseBlocks(lines []string) []*orgNode {
+	var nodes []*orgNode
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" {
+			i++
+			continue
+		}
+
+		// headlines start at column 0
+		if m := orgHeadlineRe.FindStringSubmatch(line); m != nil && indentOf(line) == 0 {
+			nodes = append(nodes, doc.parseHeadline(len(m[1]), m[2]))
+			i++
+			// planning information is not rendered
+			for i < len(lines) && isOrgPlanning(lines[i]) {
+				i++
+			}
+			continue
+		}
+
+		// comments
+		if trimmed == "#" || strings.HasPrefix(trimmed, "# ") {
+			i++
+			continue
+		}
+
+		if m := orgBeginRe.FindStringSubmatch(trimmed); m != nil {
+			var node *orgNode
+			node, i = doc.parseBlock(lines, i, strings.ToUpper(m[1]), m[2])
+			if node != nil {
+				nodes = append(nodes, node)
+			}
+			continue
+		}
+
+		// other in-buffer settings, e.g. "#+TITLE:"
+		if orgKeywordRe.MatchString(trimmed) {
+			i++
+			continue
+		}
+
+		if m := orgDrawerRe.FindStringSubmatch(trimmed); m != nil {
+			var node *orgNode
+			node, i = parseOrgDrawer(lines, i, m[1])
+			if node != nil {
+				nodes = append(nodes, node)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "|") {
+			var node *orgNode
+			node, i = parseOrgTable(lines, i)
+			nodes = append(nodes, node)
+			continue
+		}
+
+		// fixed width lines
+		if trimmed == ":" || strings.HasPrefix(trimmed, ": ") {
+			var text []string
+			for i < len(lines) {
+				t := strings.TrimLeft(lines[i], " ")
+				if t != ":" && !strings.HasPrefix(t, ": ") {
+					break
+				}
+				text = append(text, strings.TrimPrefix(strings.TrimPrefix(t, ":"), " "))
+				i++
+			}
+			nodes = append(nodes, &orgNode{kind: orgExample, text: strings.Join(text, "\n")})
+			continue
+		}
+
+		if orgRuleRe.MatchString(trimmed) {
+			nodes = append(nodes, &orgNode{kind: orgRule})
+			i++
+			continue
+		}
+
+		if _, _, ok := orgListItem(trimmed); ok {
+			var node *orgNode
+			node, i = doc.parseList(lines, i)
+			nodes = append(nodes, node)
+			continue
+		}
+
+		// paragraph
+		start := i
+		i++
+		for i < len(lines) && strings.TrimSpace(lines[i]) != "" && !doc.startsBlock(lines[i]) {
+			i++
+		}
+		var text []string
+		for _, l := range lines[start:i] {
+			text = append(text, strings.TrimSpace(l))
+		}
+		nodes = append(nodes, &orgNode{kind: orgParagraph, text: strings.Join(text, "\n")})
+	}
+
+	return nodes
+}
+
+func isOrgPlanning(line string) bool {
+	line = strings.TrimSpace(line)
+	for _, p := range []string{"SCHEDULED:", "DEADLINE:", "CLOSED:"} {
+		if strings.HasPrefix(line, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (doc *orgDocument) parseHeadline(level int, text string) *orgNode {
+	node := &orgNode{kind: orgHeadline, level: level}
+
+	if fields := strings.SplitN(text, " ", 2); len(fields) > 0 {
+		if _, ok := doc.keywords[fields[0]]; ok {
+			node.keyword = fields[0]
+			text = ""
+			if len(fields) == 2 {
+				text = strings.TrimSpace(fields[1])
+			}
+		}
+	}
+	if m := orgPriorityRe.FindStringSubmatch(text); m != nil {
+		node.priority, text = m[1], m[2]
+	}
+	if m := orgTagsRe.FindStringSubmatch(text); m != nil {
+		text = m[1]
+		for _, tag := range strings.Split(m[2], ":") {
+			if tag != "" {
+				node.tags = append(node.tags, tag)
+			}
+		}
+	}
+	node.text = text
+	return node
+}
+
+// parseBlock parses a "#+BEGIN_name" block.
+func (doc *orgDocument) parseBlock(lines []string, i int, name, args string) (*orgNode, int) {
+	end := "#+END_" + name
+	start := i + 1
+	for i = start; i < len(lines); i++ {
+		if strings.ToUpper(strings.TrimSpace(lines[i])) == end {
+			break
+		}
+	}
+	content := dedentLines(lines[start:i])
+	// skip the #+END line
+	i++
+
+	switch name {
+	case "SRC":
+		lang := ""
+		if fields := strings.Fields(args); len(fields) > 0 {
+			lang = fields[0]
+		}
+		return &orgNode{kind: orgSrc, lang: lang, text: strings.Join(content, "\n")}, i
+	case "EXAMPLE", "VERSE":
+		return &orgNode{kind: orgExample, text: strings.Join(content, "\n")}, i
+	case "QUOTE", "CENTER":
+		return &orgNode{kind: orgQuote, children: doc.parseBlocks(content)}, i
+	case "COMMENT":
+		return nil, i
+	}
+	// unknown blocks are rendered as their content
+	return &orgNode{kind: orgQuote, children: doc.parseBlocks(content)}, i
+}
+
+// parseOrgDrawer parses a drawer; only the property drawers are rendered.
+func parseOrgDrawer(lines []string, i int, name string) (*orgNode, int) {
+	start := i + 1
+	for i = start; i < len(lines); i++ {
+		if strings.ToUpper(strings.TrimSpace(lines[i])) == ":END:" {
+			break
+		}
+	}
+	content := lines[start:i]
+	i++
+
+	if strings.ToUpper(name) != "PROPERTIES" {
+		return nil, i
+	}
+	node := &orgNode{kind: orgProperties}
+	for _, line := range content {
+		if m := orgPropertyRe.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			node.rows = append(node.rows, []string{m[1], m[2]})
+		}
+	}
+	return node, i
+}
+
+func parseOrgTable(lines []string, i int) (*orgNode, int) {
+	node := &orgNode{kind: orgTable}
+
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "|") {
+			break
+		}
+		if strings.HasPrefix(line, "|-") {
+			// the rows above the first separator are the header
+			if node.header == 0 {
+				node.header = len(node.rows)
+			}
+			continue
+		}
+		line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+		var row []string
+		for _, cell := range strings.Split(line, "|") {
+			row = append(row, strings.TrimSpace(cell))
+		}
+		node.rows = append(node.rows, row)
+	}
+
+	// a separator after every row is not a header
+	if node.header == len(node.rows) {
+		node.header = 0
+	}
+	return node, i
+}
+
+func (doc *orgDocument) parseList(lines []string, i int) (*orgNode, int) {
+	indent := indentOf(lines[i])
+	kind, _, _ := orgListItem(strings.TrimLeft(lines[i], " "))
+	node := &orgNode{kind: orgList, list: kind}
+
+	for i < len(lines) {
+		line := lines[i]
+		if indentOf(line) != indent {
+			break
+		}
+		k, text, ok := orgListItem(line[indent:])
+		if !ok || k != kind {
+			break
+		}
+		// the item continues with the lines indented more than the bullet
+		content := []string{text}
+		i++
+		for i < len(lines) {
+			if lines[i] == "" {
+				// two blank lines end the list
+				if i+1 < len(lines) && lines[i+1] == "" {
+					break
+				}
+				if i+1 < len(lines) && indentOf(lines[i+1]) > indent {
+					content = append(content, "")
+					i++
+					continue
+				}
+				break
+			}
+			if indentOf(lines[i]) <= indent {
+				break
+			}
+			content = append(content, lines[i])
+			i++
+		}
+		rest := dedentLines(content[1:])
+		content = append([]string{content[0]}, rest...)
+
+		item := &orgNode{kind: orgItem}
+		if m := orgCheckboxRe.FindStringSubmatch(content[0]); m != nil {
+			item.checkbox = strings.ToUpper(m[1])
+			content[0] = m[2]
+		}
+		if n := strings.Index(content[0], " :: "); n >= 0 && kind == "-" {
+			item.term = content[0][:n]
+			content[0] = content[0][n+4:]
+			if len(node.children) == 0 {
+				node.list = "::"
+			}
+		}
+		item.children = doc.parseBlocks(content)
+		node.children = append(node.children, item)
+
+		// skip a single blank line between the items
+		if i+1 < len(lines) && lines[i] == "" && lines[i+1] != "" && indentOf(lines[i+1]) == indent {
+			i++
+		}
+	}
+	return node, i
+}
+
+// orgEmphasis maps the emphasis markers to their HTML tags.
+var orgEmphasis = map[byte]string{
+	'*': "strong",
+	'/': "em",
+	'_': "u",
+	'+': "del",
+	'=': "code",
+	'~': "code",
+}
+
+func orgPreEmphasis(b byte) bool {
+	return b == ' ' || b == '\n' || strings.IndexByte(`-({'"`, b) >= 0
+}
+
+func orgPostEmphasis(b byte) bool {
+	return b == ' ' || b == '\n' || strings.IndexByte(`-.,:!?;'")}[`, b) >= 0
+}
+
+// orgEmphasisEnd returns the position of the marker closing the emphasis
+// starting at pos, or -1.
+func orgEmphasisEnd(text string, pos int) int {
+	marker := text[pos]
+	if pos+1 >= len(text) || text[pos+1] == ' ' || text[pos+1] == '\n' {
+		return -1
+	}
+	for j := pos + 2; j < len(text); j++ {
+		if text[j] != marker || text[j-1] == ' ' || text[j-1] == '\n' {
+			continue
+		}
+		if j+1 == len(text) || orgPostEmphasis(text[j+1]) {
+			return j
+		}
+	}
+	return -1
+}
+
+// orgLinkTarget returns the URL of a link target.
+func orgLinkTarget(target string) string {
+	if strings.HasPrefix(target, "file:") {
+		return strings.TrimPrefix(target, "file:")
+	}
+	return target
+}
+
+// inline renders the inline markup of text, as HTML or as plain text.
+func (doc *orgDocument) inline(text string, plain bool) string {
+	esc := html.EscapeString
+	if plain {
+		esc = func(s string) string { return s }
+	}
+
+	var buf, pending bytes.Buffer
+	flush := func() {
+		buf.WriteString(esc(pending.String()))
+		pending.Reset()
+	}
+
+	for pos := 0; pos < len(text); {
+		c := text[pos]
+		boundary := pos == 0 || orgPreEmphasis(text[pos-1])
+
+		if c == '[' {
+			if m := orgLinkRe.FindStringSubmatch(text[pos:]); m != nil {
+				flush()
+				href, label := orgLinkTarget(m[1]), m[2]
+				switch {
+				case plain && label != "":
+					buf.WriteString(doc.inline(label, true))
+				case plain:
+					buf.WriteString(href)
+				case label == "" && orgImageRe.MatchString(href):
+					fmt.Fprintf(&buf, `<img src="%s" alt="">`, esc(href))
+				case label == "":
+					fmt.Fprintf(&buf, `<a href="%s">%s</a>`, esc(href), esc(m[1]))
+				default:
+					fmt.Fprintf(&buf, `<a href="%s">%s</a>`, esc(href), doc.inline(label, false))
+				}
+				pos += len(m[0])
+				continue
+			}
+		}
+
+		if c == 'h' && boundary {
+			if url := orgURLRe.FindString(text[pos:]); url != "" {
+				flush()
+				if plain {
+					buf.WriteString(url)
+				} else {
+					fmt.Fprintf(&buf, `<a href="%s">%s</a>`, esc(url), esc(url))
+				}
+				pos += len(url)
+				continue
+			}
+		}
+
+		if tag, ok := orgEmphasis[c]; ok && boundary {
+			if end := orgEmphasisEnd(text, pos); end > 0 {
+				flush()
+				content := text[pos+1 : end]
+				if c == '=' || c == '~' {
+					content = esc(content)
+				} else {
+					content = doc.inline(content, plain)
+				}
+				if plain {
+					buf.WriteString(content)
+				} else {
+					fmt.Fprintf(&buf, "<%s>%s</%s>", tag, content, tag)
+				}
+				pos = end + 1
+				continue
+			}
+		}
+
+		pending.WriteByte(c)
+		pos++
+	}
+	flush()
+	return buf.String()
+}
+
+func (doc *orgDocument) writeHTML(buf *bytes.Buffer, nodes []*orgNode) {
+	for _, node := range nodes {
+		switch node.kind {
+		case orgHeadline:
+			level := node.level
+			if level > 6 {
+				level = 6
+			}
+			fmt.Fprintf(buf, "<h%d>", level)
+			if node.keyword != "" {
+				fmt.Fprintf(buf, "<span class=\"%s\">%s</span> ", doc.keywords[node.keyword], html.EscapeString(node.keyword))
+			}
+			if node.priority != "" {
+				fmt.Fprintf(buf, "<span class=\"priority\">[#%s]</span> ", html.EscapeString(node.priority))
+			}
+			buf.WriteString(doc.inline(node.text, false))
+			if len(node.tags) > 0 {
+				buf.WriteString(" <span class=\"tags\">")
+				for i, tag := range node.tags {
+					if i > 0 {
+						buf.WriteString(" ")
+					}
+					fmt.Fprintf(buf, "<span class=\"tag\">%s</span>", html.EscapeString(tag))
+				}
+				buf.WriteString("</span>")
+			}
+			fmt.Fprintf(buf, "</h%d>\n", level)
+		case orgParagraph:
+			fmt.Fprintf(buf, "<p>%s</p>\n", doc.inline(node.text, false))
+		case orgProperties:
+			buf.WriteString("<dl class=\"properties\">\n")
+			for _, row := range node.rows {
+				fmt.Fprintf(buf, "<dt>%s</dt>\n<dd>%s</dd>\n", html.EscapeString(row[0]), doc.inline(row[1], false))
+			}
+			buf.WriteString("</dl>\n")
+		case orgList:
+			tag := "ul"
+			switch node.list {
+			case "1":
+				tag = "ol"
+			case "::":
+				tag = "dl"
+			}
+			fmt.Fprintf(buf, "<%s>\n", tag)
+			for _, item := range node.children {
+				doc.writeItem(buf, node.list, item)
+			}
+			fmt.Fprintf(buf, "</%s>\n", tag)
+		case orgTable:
+			buf.WriteString("<table class=\"table\">\n")
+			for r, row := range node.rows {
+				cellTag := "td"
+				if r < node.header {
+					cellTag = "th"
+				}
+				buf.WriteString("<tr>")
+				for _, cell := range row {
+					fmt.Fprintf(buf, "<%s>%s</%s>", cellTag, doc.inline(cell, false), cellTag)
+				}
+				buf.WriteString("</tr>\n")
+			}
+			buf.WriteString("</table>\n")
+		case orgSrc:
+			if node.lang != "" {
+				fmt.Fprintf(buf, "<pre><code class=\"language-%s\">%s</code></pre>\n", html.EscapeString(node.lang), html.EscapeString(node.text))
+			} else {
+				fmt.Fprintf(buf, "<pre><code>%s</code></pre>\n", html.EscapeString(node.text))
+			}
+		case orgExample:
+			fmt.Fprintf(buf, "<pre>%s</pre>\n", html.EscapeString(node.text))
+		case orgQuote:
+			buf.WriteString("<blockquote>\n")
+			doc.writeHTML(buf, node.children)
+			buf.WriteString("</blockquote>\n")
+		case orgRule:
+			buf.WriteString("<hr>\n")
+		}
+	}
+}
+
+// writeItem writes a list item; an item made of a single paragraph is
+// written without the <p> tag.
+func (doc *orgDocument) writeItem(buf *bytes.Buffer, list string, item *orgNode) {
+	if list == "::" {
+		fmt.Fprintf(buf, "<dt>%s</dt>\n<dd>", doc.inline(item.term, false))
+	} else {
+		buf.WriteString("<li>")
+	}
+
+	switch item.checkbox {
+	case " ":
+		buf.WriteString(`<input type="checkbox" disabled> `)
+	case "X":
+		buf.WriteString(`<input type="checkbox" checked disabled> `)
+	case "-":
+		buf.WriteString(`<input type="checkbox" class="partial" disabled> `)
+	}
+
+	if len(item.children) == 1 && item.children[0].kind == orgParagraph {
+		buf.WriteString(doc.inline(item.children[0].text, false))
+	} else if len(item.children) > 0 && item.children[0].kind == orgParagraph {
+		// keep the first line of the item next to the bullet
+		buf.WriteString(doc.inline(item.children[0].text, false))
+		buf.WriteString("\n")
+		doc.writeHTML(buf, item.children[1:])
+	} else {
+		doc.writeHTML(buf, item.children)
+	}
+
+	if list == "::" {
+		buf.WriteString("</dd>\n")
+	} else {
+		buf.WriteString("</li>\n")
+	}
+}
+
+func (doc *orgDocument) writeText(buf *bytes.Buffer, nodes []*orgNode) {
+	for _, node := range nodes {
+		switch node.kind {
+		case orgHeadline:
+			buf.WriteString(doc.inline(node.text, true))
+			if len(node.tags) > 0 {
+				buf.WriteString(" ")
+				buf.WriteString(strings.Join(node.tags, " "))
+			}
+			buf.WriteString("\n\n")
+		case orgParagraph:
+			buf.WriteString(doc.inline(node.text, true))
+			buf.WriteString("\n\n")
+		case orgSrc, orgExample:
+			buf.WriteString(node.text)
+			buf.WriteString("\n\n")
+		case orgProperties, orgTable:
+			for _, row := range node.rows {
+				var cells []string
+				for _, cell := range row {
+					cells = append(cells, doc.inline(cell, true))
+				}
+				buf.WriteString(strings.Join(cells, " "))
+				buf.WriteString("\n")
+			}
+			buf.WriteString("\n")
+		case orgList:
+			for _, item := range node.children {
+				if item.term != "" {
+					buf.WriteString(doc.inline(item.term, true))
+					buf.WriteString("\n")
+				}
+				doc.writeText(buf, item.children)
+			}
+		case orgQuote:
+			doc.writeText(buf, node.children)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package spock
+
+import (
+	"strings"
+	"testing"
+)
+
+func checkOrg(t *testing.T, source string, expected ...string) {
+	html, err := renderOrgNative([]byte(source))
+	checkFatal(t, err)
+	for _, s := range expected {
+		if !strings.Contains(string(html), s) {
+			t.Errorf("rendered org should contain %q:\n%s", s, html)
+		}
+	}
+}
+
+func TestOrgHeadlines(t *testing.T) {
+	checkOrg(t, "* TODO [#A] Write the docs  :work:wiki:\nSCHEDULED: <2016-01-01>\n** DONE Sub\n",
+		`<h1><span class="todo">TODO</span> <span class="priority">[#A]</span> Write the docs <span class="tags"><span class="tag">work</span> <span class="tag">wiki</span></span></h1>`,
+		`<h2><span class="done">DONE</span> Sub</h2>`)
+	checkOrg(t, "#+TODO: NEXT WAIT(w) | CANCELLED\n* WAIT Reply\n* CANCELLED Old\n* TODO Not a keyword\n",
+		`<span class="todo">WAIT</span> Reply`, `<span class="done">CANCELLED</span> Old`, "<h1>TODO Not a keyword</h1>")
+}
+
+func TestOrgProperties(t *testing.T) {
+	checkOrg(t, "* Host\n:PROPERTIES:\n:IP: 10.0.0.1\n:END:\n:LOGBOOK:\n- hidden\n:END:\n",
+		"<dl class=\"properties\">\n<dt>IP</dt>\n<dd>10.0.0.1</dd>\n</dl>")
+	html, err := renderOrgNative([]byte(":LOGBOOK:\n- hidden\n:END:\n"))
+	checkFatal(t, err)
+	if strings.Contains(string(html), "hidden") {
+		t.Fatalf("drawers should not be rendered: %s", html)
+	}
+}
+
+func TestOrgLists(t *testing.T) {
+	checkOrg(t, "- one\n- two\n  continued\n  + nested\n- [X] done\n- [ ] todo\n",
+		"<ul>\n<li>one</li>", "<li>two\ncontinued\n<ul>\n<li>nested</li>\n</ul>\n</li>",
+		`<li><input type="checkbox" checked disabled> done</li>`, `<li><input type="checkbox" disabled> todo</li>`)
+	checkOrg(t, "1. first\n2) second\n", "<ol>\n<li>first</li>\n<li>second</li>\n</ol>")
+	checkOrg(t, "- Go :: a language\n- Org :: a mode\n", "<dl>\n<dt>Go</dt>\n<dd>a language</dd>")
+}
+
+func TestOrgBlocks(t *testing.T) {
+	checkOrg(t, "#+BEGIN_SRC go :tangle no\n  if a < b {\n  }\n#+END_SRC\n",
+		"<pre><code class=\"language-go\">if a &lt; b {\n}</code></pre>")
+	checkOrg(t, "#+begin_quote\nWise words.\n#+end_quote\n", "<blockquote>\n<p>Wise words.</p>\n</blockquote>")
+	checkOrg(t, ": $ ls\n: file\n", "<pre>$ ls\nfile</pre>")
+	checkOrg(t, "| Name | Value |\n|------+-------|\n| a    | 1     |\n",
+		"<tr><th>Name</th><th>Value</th></tr>", "<tr><td>a</td><td>1</td></tr>")
+	checkOrg(t, "one\n-----\n# a comment\ntwo\n", "<p>one</p>\n<hr>\n<p>two</p>")
+}
+
+func TestOrgInline(t *testing.T) {
+	checkOrg(t, "Some *bold*, /italic/, _under_, +gone+, =x<y= and ~code~.",
+		"<strong>bold</strong>", "<em>italic</em>", "<u>under</u>", "<del>gone</del>",
+		"<code>x&lt;y</code>", "<code>code</code>.")
+	checkOrg(t, "2*3*4 and a/b/c stay.", "2*3*4 and a/b/c stay.")
+	checkOrg(t, "See [[https://orgmode.org][the *manual*]], [[file:notes/index.org]], [[/img/logo.png]] and https://example.com.",
+		`<a href="https://orgmode.org">the <strong>manual</strong></a>`,
+		`<a href="notes/index.org">file:notes/index.org</a>`, `<img src="/img/logo.png" alt="">`,
+		`<a href="https://example.com">https://example.com</a>.`)
+}
+
+func TestOrgPlain(t *testing.T) {
+	txt, err := renderOrgNativePlain([]byte("#+TITLE: Notes\n* TODO Task :work:\nSome *bold* [[https://x.org][link]].\n"))
+	checkFatal(t, err)
+	expected := "Task work\n\nSome bold link.\n\n"
+	if string(txt) != expected {
+		t.Fatalf("plain text should be %q, is %q", expected, txt)
+	}
+}
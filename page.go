@@ -6,6 +6,7 @@ package spock
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"errors"
 	"fmt"
 	"github.com/mschoch/blackfriday-text"
@@ -16,6 +17,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"time"
 )
 
@@ -36,6 +39,11 @@ const (
 	orgName      = "org"
 
 	DefaultExtension = "md"
+
+	// Pages shown next to and below every page of their directory and its
+	// subdirectories.
+	SidebarPageName = "_sidebar"
+	FooterPageName  = "_footer"
 )
 
 const (
@@ -53,23 +61,124 @@ var (
 
 	pandocEnabled bool = false
 	pandocExe          = ""
+
+	// PreferPandoc renders the reStructuredText and Org pages with pandoc,
+	// when available, instead of the built-in renderers.
+	PreferPandoc = false
 )
 
 func init() {
 	var err error
 	if pandocExe, err = exec.LookPath("pandoc"); err == nil {
 		pandocEnabled = true
-	} else {
-		log.Printf("Cannot find pandoc, disabling extra renderers\n")
 	}
 }
 
 // PageHeader is the optional YAML header of a wiki page.
 type PageHeader struct {
-	Title       string
-	Description string
-	Language    string
-	Markup      string
+	Title       string   `yaml:"title,omitempty"`
+	Description string   `yaml:"description,omitempty"`
+	Language    string   `yaml:"language,omitempty"`
+	Markup      string   `yaml:"markup,omitempty"`
+	Tags        []string `yaml:"tags,omitempty"`
+	// Other paths of the page, e.g. the old ones.
+	Aliases []string `yaml:"aliases,omitempty"`
+	// The page is only a redirect to another page.
+	Redirect string `yaml:"redirect,omitempty"`
+	// The maintainer of the page.
+	Owner string `yaml:"owner,omitempty"`
+	Draft bool   `yaml:"draft,omitempty"`
+	// Creation and last update dates, e.g. "2016-03-14".
+	Created string `yaml:"created,omitempty"`
+	Updated string `yaml:"updated,omitempty"`
+	// The page body is an OpenPGP message, see PageCrypter.
+	Encrypted bool `yaml:"encrypted,omitempty"`
+	// The header fields not listed above.
+	Custom map[string]interface{} `yaml:"-"`
+}
+
+// headerDateFormats are the accepted formats of the Created and Updated
+// header fields.
+var headerDateFormats = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+}
+
+// parseHeaderDate parses a date of the page header; the zero time is
+// returned for invalid dates.
+func parseHeaderDate(value string) time.Time {
+	for _, format := range headerDateFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// CreatedTime returns the creation date of the page, or the zero time.
+func (ph *PageHeader) CreatedTime() time.Time {
+	return parseHeaderDate(ph.Created)
+}
+
+// UpdatedTime returns the last update date of the page, or the zero time.
+func (ph *PageHeader) UpdatedTime() time.Time {
+	return parseHeaderDate(ph.Updated)
+}
+
+// pageHeaderFields returns the YAML names of the PageHeader fields.
+func pageHeaderFields() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(PageHeader{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// parseCustomFields returns the fields of a YAML header unknown to
+// PageHeader.
+func parseCustomFields(header []byte) (map[string]interface{}, error) {
+	var all map[string]interface{}
+	if err := yaml.Unmarshal(header, &all); err != nil {
+		return nil, err
+	}
+
+	known := pageHeaderFields()
+	var custom map[string]interface{}
+	for key, value := range all {
+		if known[key] {
+			continue
+		}
+		if custom == nil {
+			custom = make(map[string]interface{})
+		}
+		custom[key] = normalizeYAML(value)
+	}
+	return custom, nil
+}
+
+// normalizeYAML converts the maps decoded by yaml to maps with string keys,
+// which can be indexed and encoded to JSON.
+func normalizeYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalizeYAML(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeYAML(item)
+		}
+		return v
+	}
+	return value
 }
 
 // Page is a wiki page. The Path attribute contains the relative path
@@ -94,7 +203,7 @@ func ParsePageBytes(data []byte) (*PageHeader, []byte, error) {
 	ph := &PageHeader{}
 
 	// if the first bytes does not contain the YAML header
-	if !bytes.Equal(data[0:len(headerTag)], headerTag) {
+	if !bytes.HasPrefix(data, headerTag) {
 		return ph, data, nil
 	} else {
 		// read and parse the YAML header
@@ -122,11 +231,55 @@ func ParsePageBytes(data []byte) (*PageHeader, []byte, error) {
 		if err != nil {
 			return nil, content, err
 		}
+		if ph.Custom, err = parseCustomFields(header); err != nil {
+			return nil, content, err
+		}
 	}
 
 	return ph, content, nil
 }
 
+// FormatPageBytes is the reverse of ParsePageBytes: it returns a page made
+// of the YAML header ph, omitted when empty, and content.
+func FormatPageBytes(ph *PageHeader, content []byte) ([]byte, error) {
+	header, err := yaml.Marshal(ph)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(header, []byte("{}\n")) {
+		header = nil
+	}
+
+	known := pageHeaderFields()
+	custom := make(map[string]interface{})
+	for key, value := range ph.Custom {
+		if !known[key] {
+			custom[key] = value
+		}
+	}
+	if len(custom) > 0 {
+		fields, err := yaml.Marshal(custom)
+		if err != nil {
+			return nil, err
+		}
+		header = append(header, fields...)
+	}
+	if header == nil {
+		return content, nil
+	}
+
+	var buf bytes.Buffer
+	buf.Write(headerTag)
+	buf.WriteString("\n")
+	buf.Write(header)
+	buf.Write(headerTag)
+	if !bytes.HasPrefix(content, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	buf.Write(content)
+	return buf.Bytes(), nil
+}
+
 // LoadPage loads a page from the filesystem; the "path" argument must be an
 // absolute filename, and the "relpath" must be relative "wiki path" plus
 // the file extension; example arguments:
@@ -176,11 +329,30 @@ func ShortenPageName(name string) string {
 	return name
 }
 
+// IsPageFilename returns true if name has one of the page extensions.
+func IsPageFilename(name string) bool {
+	ext := filepath.Ext(name)
+	for _, pageExt := range PAGE_EXTENSIONS {
+		if ext == "."+pageExt {
+			return true
+		}
+	}
+	return false
+}
+
 // ShortName is the "short" (i.e. without the filename extension) name of a page.
 func (page *Page) ShortName() string {
 	return ShortenPageName(page.Path)
 }
 
+// BlobID returns the id of the git blob holding the page content.
+func (page *Page) BlobID() string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(page.RawBytes))
+	h.Write(page.RawBytes)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
 func (page *Page) String() string {
 	return fmt.Sprintf("Page[%s]", page.Path)
 }
@@ -205,54 +377,66 @@ func (page *Page) GetMarkup() (markup string) {
 	return
 }
 
-// Render renders the HTML version of a Wiki page.
-func (page *Page) Render() (html []byte, err error) {
-	markup := page.GetMarkup()
+// Render renders the HTML version of a Wiki page; the pages included with
+// "{{include: path}}" are found with lookup, usually Storage.LookupPage.
+func (page *Page) Render(lookup LookupFunc) ([]byte, error) {
+	return page.RenderPreview(lookup, page.Content)
+}
+
+// RenderPlaintext renders the text of a Wiki page and of the pages it
+// includes, without markup.
+func (page *Page) RenderPlaintext(lookup LookupFunc) ([]byte, error) {
+	rendered, err := page.renderParts(page.Content, true)
+	if err != nil {
+		return nil, err
+	}
+	return renderIncludes(nil, lookup, page, rendered, true, nil), nil
+}
+
+// RenderPreview is like Render but renders content instead of the body of
+// the page.
+func (page *Page) RenderPreview(lookup LookupFunc, content []byte) ([]byte, error) {
+	rendered, err := page.renderParts(content, false)
+	if err != nil {
+		return rendered.content, err
+	}
+	return renderIncludes(nil, lookup, page, rendered, false, nil), nil
+}
+
+func renderMarkup(markup string, content []byte) (html []byte, err error) {
 	switch markup {
 	case markdownName:
-		html, err = renderMarkdown(page.Content)
+		html, err = renderMarkdown(content)
 	case rstName:
-		html, err = renderRst(page.Content)
+		html, err = renderRst(content)
 	case orgName:
-		html, err = renderOrg(page.Content)
+		html, err = renderOrg(content)
 	default:
-		html, err = []byte(page.Content), fmt.Errorf("Unknown format: %s", markup)
+		return content, fmt.Errorf("Unknown format: %s", markup)
 	}
-	return html, err
+	if err != nil {
+		return html, err
+	}
+	return highlightCode(renderDiagrams(html)), nil
 }
 
-func (page *Page) RenderPlaintext() (txt []byte, err error) {
-	switch page.GetMarkup() {
+func renderPlaintext(markup string, content []byte) (txt []byte, err error) {
+	switch markup {
 	case markdownName:
 		extensions := 0
 		renderer := blackfridaytext.TextRenderer()
-		txt, err = blackfriday.Markdown(page.Content, renderer, extensions), nil
+		txt, err = blackfriday.Markdown(content, renderer, extensions), nil
 	case rstName:
-		txt, err = renderRstPlain(page.Content)
+		txt, err = renderRstPlain(content)
 	case orgName:
-		txt, err = renderOrgPlain(page.Content)
+		txt, err = renderOrgPlain(content)
 	default:
 		// we won't return an error because text rendering is "best effort" :)
-		txt, err = page.RawBytes, nil
+		txt, err = content, nil
 	}
 	return txt, err
 }
 
-func (page *Page) RenderPreview(content []byte) (html []byte, err error) {
-	markup := page.GetMarkup()
-	switch markup {
-	case markdownName:
-		html, err = renderMarkdown(content)
-	case rstName:
-		html, err = renderRst(content)
-	case orgName:
-		html, err = renderOrg(content)
-	default:
-		html, err = []byte(content), fmt.Errorf("Unknown format: %s", markup)
-	}
-	return html, err
-}
-
 func renderMarkdown(content []byte) ([]byte, error) {
 	// Add TOC to the HTML output
 	htmlFlags := 0
@@ -319,17 +503,29 @@ func renderPandoc(content []byte, srcType uint8, dstType uint8, extraArgs ...str
 }
 
 func renderRst(content []byte) ([]byte, error) {
-	return renderPandoc(content, PANDOC_IN_RST, PANDOC_OUT_HTML)
+	if PreferPandoc && pandocEnabled {
+		return renderPandoc(content, PANDOC_IN_RST, PANDOC_OUT_HTML)
+	}
+	return renderRstNative(content)
 }
 
 func renderOrg(content []byte) ([]byte, error) {
-	return renderPandoc(content, PANDOC_IN_ORG, PANDOC_OUT_HTML)
+	if PreferPandoc && pandocEnabled {
+		return renderPandoc(content, PANDOC_IN_ORG, PANDOC_OUT_HTML)
+	}
+	return renderOrgNative(content)
 }
 
 func renderRstPlain(content []byte) ([]byte, error) {
-	return renderPandoc(content, PANDOC_IN_RST, PANDOC_OUT_TXT)
+	if PreferPandoc && pandocEnabled {
+		return renderPandoc(content, PANDOC_IN_RST, PANDOC_OUT_TXT)
+	}
+	return renderRstNativePlain(content)
 }
 
 func renderOrgPlain(content []byte) ([]byte, error) {
-	return renderPandoc(content, PANDOC_IN_ORG, PANDOC_OUT_TXT)
+	if PreferPandoc && pandocEnabled {
+		return renderPandoc(content, PANDOC_IN_ORG, PANDOC_OUT_TXT)
+	}
+	return renderOrgNativePlain(content)
 }
@@ -0,0 +1,104 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// Page templates: the initial content of the new pages is taken from the
+// pages stored in the _templates directory.
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PageTemplatesDir is the directory containing the page templates.
+const PageTemplatesDir = "_templates"
+
+// DefaultPageTemplate is the name of the template used when a directory
+// doesn't set its own default.
+const DefaultPageTemplate = "default"
+
+// PageTemplateVars are the values of the variables replaced in a template.
+type PageTemplateVars struct {
+	// path of the new page, without extension.
+	Path   string
+	Author string
+	Email  string
+	Time   time.Time
+}
+
+// ListPageTemplates returns the names of the page templates, relative to
+// PageTemplatesDir.
+func ListPageTemplates(storage Storage) ([]string, error) {
+	pages, err := storage.ListPages()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, page := range pages {
+		if strings.HasPrefix(page, PageTemplatesDir+"/") {
+			result = append(result, strings.TrimPrefix(page, PageTemplatesDir+"/"))
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// DirectoryPageTemplate returns the name of the template used for the new
+// pages created in dir: defaults maps a directory to the name of its
+// template, and the nearest parent directory found in defaults wins.
+func DirectoryPageTemplate(defaults map[string]string, dir string) string {
+	dir = cleanTreePath(dir)
+	for {
+		if name, ok := defaults[dir]; ok {
+			return name
+		}
+		if dir == "" {
+			break
+		}
+		if dir = path.Dir(dir); dir == "." {
+			dir = ""
+		}
+	}
+	return DefaultPageTemplate
+}
+
+// ExpandPageTemplate replaces the variables found in the content of a
+// template: {{path}}, {{name}}, {{title}}, {{dir}}, {{date}}, {{time}},
+// {{author}} and {{email}}. Unknown variables are left untouched.
+func ExpandPageTemplate(content []byte, vars PageTemplateVars) []byte {
+	dir := path.Dir(vars.Path)
+	if dir == "." {
+		dir = ""
+	}
+	name := path.Base(vars.Path)
+
+	replacer := strings.NewReplacer(
+		"{{path}}", vars.Path,
+		"{{name}}", name,
+		"{{title}}", strings.Replace(name, "-", " ", -1),
+		"{{dir}}", dir,
+		"{{date}}", vars.Time.Format("2006-01-02"),
+		"{{time}}", vars.Time.Format("15:04"),
+		"{{author}}", vars.Author,
+		"{{email}}", vars.Email,
+	)
+	return []byte(replacer.Replace(string(content)))
+}
+
+// NewPageFromTemplate returns the initial content of a new page, made from
+// the template called name; NewPageContent is returned when the template
+// doesn't exist.
+func NewPageFromTemplate(storage Storage, name string, vars PageTemplateVars) ([]byte, error) {
+	template, exists, err := storage.LookupPage(path.Join(PageTemplatesDir, cleanTreePath(name)))
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return []byte(NewPageContent), nil
+	}
+	return ExpandPageTemplate(template.RawBytes, vars), nil
+}
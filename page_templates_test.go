@@ -0,0 +1,76 @@
+package spock
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpandPageTemplate(t *testing.T) {
+	vars := PageTemplateVars{
+		Path:   "runbooks/disk-full",
+		Author: "Palle Nyborg",
+		Email:  "palle@superfoppa.com",
+		Time:   time.Date(2016, 3, 14, 9, 5, 0, 0, time.UTC),
+	}
+	content := "# {{title}}\n\n{{path}} {{name}} in {{dir}}, {{date}} {{time}} by {{author}} <{{email}}> {{unknown}}"
+	expected := "# disk full\n\nrunbooks/disk-full disk-full in runbooks, 2016-03-14 09:05 by Palle Nyborg <palle@superfoppa.com> {{unknown}}"
+	if rv := string(ExpandPageTemplate([]byte(content), vars)); rv != expected {
+		t.Fatalf("expanded template should be %q, is %q", expected, rv)
+	}
+
+	vars.Path = "index"
+	if rv := string(ExpandPageTemplate([]byte("[{{dir}}]"), vars)); rv != "[]" {
+		t.Fatalf("the directory of a page in the root should be empty, is %q", rv)
+	}
+}
+
+func TestDirectoryPageTemplate(t *testing.T) {
+	defaults := map[string]string{"runbooks": "runbook", "runbooks/db/mysql": "mysql"}
+	tests := map[string]string{
+		"runbooks":             "runbook",
+		"runbooks/network":     "runbook",
+		"runbooks/db/mysql/v5": "mysql",
+		"notes":                DefaultPageTemplate,
+		".":                    DefaultPageTemplate,
+	}
+	for dir, expected := range tests {
+		if name := DirectoryPageTemplate(defaults, dir); name != expected {
+			t.Errorf("template of %s should be %s, is %s", dir, expected, name)
+		}
+	}
+
+	defaults[""] = "note"
+	if name := DirectoryPageTemplate(defaults, "notes"); name != "note" {
+		t.Errorf("template of notes should be note, is %s", name)
+	}
+}
+
+func TestNewPageFromTemplate(t *testing.T) {
+	gs := createTestRepo(t)
+	defer cleanup(t, gs)
+
+	checkFatal(t, os.Mkdir(filepath.Join(gs.WorkDir, PageTemplatesDir), 0755))
+	createTestPage(t, gs, "_templates/runbook.md", "# {{title}}\n\nOwner: {{author}}\n", "Palle", "palle@superfoppa.com", "template", time.Now())
+	createTestPage(t, gs, "_templates/meeting.md", "# Meeting\n", "Palle", "palle@superfoppa.com", "template", time.Now())
+
+	templates, err := ListPageTemplates(gs)
+	checkFatal(t, err)
+	if len(templates) != 2 || templates[0] != "meeting" || templates[1] != "runbook" {
+		t.Fatalf("templates should be [meeting runbook], are %v", templates)
+	}
+
+	vars := PageTemplateVars{Path: "runbooks/disk-full", Author: "Palle"}
+	content, err := NewPageFromTemplate(gs, "runbook", vars)
+	checkFatal(t, err)
+	if expected := "# disk full\n\nOwner: Palle\n"; string(content) != expected {
+		t.Fatalf("content should be %q, is %q", expected, content)
+	}
+
+	content, err = NewPageFromTemplate(gs, "missing", vars)
+	checkFatal(t, err)
+	if string(content) != NewPageContent {
+		t.Fatalf("a missing template should give NewPageContent, got %q", content)
+	}
+}
@@ -1,7 +1,9 @@
 package spock
 
 import (
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestShortName(t *testing.T) {
@@ -41,3 +43,83 @@ A page about my animals
 		t.Fatal("content is different from expected")
 	}
 }
+
+func TestParsePageBytesShort(t *testing.T) {
+	ph, content, err := ParsePageBytes([]byte("#"))
+	checkFatal(t, err)
+	if ph.Title != "" || string(content) != "#" {
+		t.Fatal("a short page without header should be returned unchanged")
+	}
+}
+
+var fullHeaderBytes = []byte(`---
+title: Disk full
+tags: [ops, storage]
+aliases: [runbooks/disk]
+owner: ops@example.com
+draft: true
+created: 2016-03-14
+updated: "2016-04-01 10:30"
+encrypted: false
+severity: 2
+escalation:
+  primary: alice
+  secondary: [bob, carol]
+---
+# Disk full
+`)
+
+func TestParsePageBytesFields(t *testing.T) {
+	ph, content, err := ParsePageBytes(fullHeaderBytes)
+	checkFatal(t, err)
+
+	if len(ph.Tags) != 2 || ph.Tags[0] != "ops" || ph.Tags[1] != "storage" {
+		t.Errorf("tags should be [ops storage], are %v", ph.Tags)
+	}
+	if len(ph.Aliases) != 1 || ph.Aliases[0] != "runbooks/disk" {
+		t.Errorf("aliases should be [runbooks/disk], are %v", ph.Aliases)
+	}
+	if ph.Owner != "ops@example.com" || !ph.Draft {
+		t.Errorf("owner and draft were not parsed: %q %v", ph.Owner, ph.Draft)
+	}
+	if created := ph.CreatedTime(); !created.Equal(time.Date(2016, 3, 14, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("created should be 2016-03-14, is %s", created)
+	}
+	if updated := ph.UpdatedTime(); !updated.Equal(time.Date(2016, 4, 1, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("updated should be 2016-04-01 10:30, is %s", updated)
+	}
+
+	if len(ph.Custom) != 2 || ph.Custom["severity"] != 2 {
+		t.Fatalf("custom fields should contain severity and escalation, are %v", ph.Custom)
+	}
+	escalation, ok := ph.Custom["escalation"].(map[string]interface{})
+	if !ok || escalation["primary"] != "alice" {
+		t.Fatalf("escalation should be a map, is %#v", ph.Custom["escalation"])
+	}
+
+	// round trip
+	data, err := FormatPageBytes(ph, content)
+	checkFatal(t, err)
+	ph2, content2, err := ParsePageBytes(data)
+	checkFatal(t, err)
+	if !reflect.DeepEqual(ph, ph2) {
+		t.Fatalf("the header changed after a round trip:\n%#v\n%#v\n%s", ph, ph2, data)
+	}
+	if string(content2) != string(content) {
+		t.Fatalf("the content changed after a round trip: %q", content2)
+	}
+}
+
+func TestFormatPageBytesEmptyHeader(t *testing.T) {
+	data, err := FormatPageBytes(&PageHeader{}, []byte("# Hello\n"))
+	checkFatal(t, err)
+	if string(data) != "# Hello\n" {
+		t.Fatalf("an empty header should be omitted, got %q", data)
+	}
+
+	data, err = FormatPageBytes(&PageHeader{Title: "Hello"}, []byte("# Hello\n"))
+	checkFatal(t, err)
+	if expected := "---\ntitle: Hello\n---\n# Hello\n"; string(data) != expected {
+		t.Fatalf("page should be %q, is %q", expected, data)
+	}
+}
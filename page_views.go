@@ -5,14 +5,19 @@
 package spock
 
 import (
+	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"golang.org/x/net/xsrftoken"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"path"
+	"regexp"
 	"runtime"
 	"strings"
 	"time"
@@ -24,6 +29,8 @@ const (
 	maxBreadcrumbs = 10
 )
 
+var revisionRe = regexp.MustCompile(`^[a-zA-Z0-9]{40}$`)
+
 type breadcrumbs struct {
 	Pages []string
 }
@@ -84,13 +91,138 @@ func convertNewlines(text string) string {
 func ServeFile(w http.ResponseWriter, r *vRequest) {
 	vars := mux.Vars(r.Request)
 	relfilename := vars["filename"]
-	filename, err := r.Ctx.Storage.JoinPath(relfilename)
-	if err != nil {
+	data, mtime, err := r.Ctx.Storage.ReadFile(relfilename)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r.Request)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	http.ServeFile(w, r.Request, filename)
+	http.ServeContent(w, r.Request, path.Base(relfilename), mtime, bytes.NewReader(data))
+}
+
+// viewedBranch returns the branch chosen with the branch selector, or an
+// empty string when browsing the branch the wiki commits to.
+func viewedBranch(r *vRequest) string {
+	branch, _ := r.Session.Values["branch"].(string)
+	return branch
+}
+
+// lookupPage fetches a page from the working directory or, when branch is
+// not empty, from another branch.
+func lookupPage(storage Storage, branch, pagepath string) (*Page, bool, error) {
+	if branch != "" {
+		return storage.LookupPageAt(branch, pagepath)
+	}
+	return storage.LookupPage(pagepath)
+}
+
+// branchLookup returns a LookupFunc finding the pages of branch.
+func branchLookup(storage Storage, branch string) LookupFunc {
+	return func(pagepath string) (*Page, bool, error) {
+		return lookupPage(storage, branch, pagepath)
+	}
+}
+
+// readOnlyBranch returns true, redirecting to the page, when the user is
+// browsing a branch other than the one the wiki commits to.
+func readOnlyBranch(w http.ResponseWriter, r *vRequest, pagepath string) bool {
+	branch := viewedBranch(r)
+	if branch == "" {
+		return false
+	}
+
+	AddAlert(fmt.Sprintf("The branch %s is read only", branch), "warning", r)
+	r.Session.Save(r.Request, w)
+	http.Redirect(w, r.Request, "/"+pagepath, http.StatusFound)
+	return true
+}
+
+// SelectBranch sets the branch browsed by the user; an empty name, or the
+// name of the current branch, goes back to the current branch.
+func SelectBranch(w http.ResponseWriter, r *vRequest) {
+	name := r.Request.URL.Query().Get("name")
+
+	current, err := r.Ctx.Storage.CurrentBranch()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	branches, err := r.Ctx.Storage.Branches()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if name == "" || name == current {
+		delete(r.Session.Values, "branch")
+	} else {
+		found := false
+		for _, branch := range branches {
+			if branch == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			AddAlert(fmt.Sprintf("Unknown branch %s", name), "danger", r)
+		} else {
+			r.Session.Values["branch"] = name
+		}
+	}
+	r.Session.Save(r.Request, w)
+
+	redirect := "/"
+	if referer, err := url.Parse(r.Request.Referer()); err == nil && referer.Path != "" {
+		redirect = referer.Path
+	}
+	http.Redirect(w, r.Request, redirect, http.StatusFound)
+}
+
+// canDecrypt returns true if the user is allowed to read the encrypted
+// pages; the user must have logged in with a password.
+func canDecrypt(r *vRequest) bool {
+	if !isVerified(r) || r.Ctx.Config == nil {
+		return false
+	}
+	return r.Ctx.Config.CanDecrypt(r.AuthUser.Email)
+}
+
+// decryptPage decrypts an encrypted page for the users allowed to read it;
+// it returns false, after sending an error, when the page can't be shown.
+func decryptPage(w http.ResponseWriter, r *vRequest, page *Page) bool {
+	if !page.Header.Encrypted {
+		return true
+	}
+	if r.Ctx.Crypter == nil {
+		http.Error(w, "Page encryption is not configured", http.StatusInternalServerError)
+		return false
+	}
+	if !canDecrypt(r) {
+		http.Error(w, "This page is encrypted", http.StatusForbidden)
+		return false
+	}
+	if err := r.Ctx.Crypter.DecryptPage(page); err != nil {
+		http.Error(w, fmt.Sprintf("Cannot decrypt the page: %s", err), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// encryptPage encrypts the body of a page before saving it.
+func encryptPage(r *vRequest, page *Page) error {
+	if !page.Header.Encrypted {
+		return nil
+	}
+	if r.Ctx.Crypter == nil {
+		return errors.New("Page encryption is not configured")
+	}
+	if !canDecrypt(r) {
+		return errors.New("You are not allowed to write encrypted pages")
+	}
+	return r.Ctx.Crypter.EncryptPage(page)
 }
 
 func ShowPage(w http.ResponseWriter, r *vRequest) {
@@ -99,23 +231,52 @@ func ShowPage(w http.ResponseWriter, r *vRequest) {
 	renderStart := time.Now()
 
 	pagepath := getPagePath(r)
-	page, exists, err := r.Ctx.Storage.LookupPage(pagepath)
+	branch := viewedBranch(r)
+	page, exists, err := lookupPage(r.Ctx.Storage, branch, pagepath)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
+	} else if !exists && branch != "" {
+		http.NotFound(w, r.Request)
+		return
 	} else if !exists {
+		// the path may be an alias of another page
+		if target, ok, err := r.Ctx.Index.ResolveAlias(pagepath); err != nil {
+			log.Printf("Error resolving alias %s: %s\n", pagepath, err)
+		} else if ok {
+			redirectToPage(w, r, target, pagepath)
+			return
+		}
 		EditNewPage(page, w, r)
 		return
 	}
 
-	html, err := page.Render()
+	// "?redirect=no" shows the redirect page itself.
+	if page.Header.Redirect != "" && r.Request.URL.Query().Get("redirect") != "no" {
+		target, err := ResolveRedirect(branchLookup(r.Ctx.Storage, branch), page)
+		if err == nil {
+			redirectToPage(w, r, target, page.ShortName())
+			return
+		}
+		AddAlert(err.Error(), "danger", r)
+	}
+	if !decryptPage(w, r, page) {
+		return
+	}
+
+	html, err := r.Ctx.RenderCache.Render(page, branchLookup(r.Ctx.Storage, branch))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	pageBasePath := path.Dir(r.Request.URL.Path)
-	pageList, err := r.Ctx.Storage.ListPages()
+	var pageList []string
+	if branch != "" {
+		pageList, err = r.Ctx.Storage.ListPagesAt(branch)
+	} else {
+		pageList, err = listLinkablePages(r.Ctx)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -128,16 +289,148 @@ func ShowPage(w http.ResponseWriter, r *vRequest) {
 	ctx["breadcrumbs"] = updateBreadcrumbs(w, r, page)
 	ctx["page"] = page
 	ctx["content"] = template.HTML(html)
+	ctx["sidebar"] = renderPagePart(r.Ctx, branch, page, SidebarPageName, pageList, pageBasePath)
+	ctx["footer"] = renderPagePart(r.Ctx, branch, page, FooterPageName, pageList, pageBasePath)
 	ctx["render_time"] = time.Since(renderStart)
 	ctx["alerts"] = GetAlerts(r, w)
 
 	r.Ctx.RenderTemplate("page.html", ctx, w)
 }
 
+// redirectToPage redirects to the page target, telling the reader which
+// page they were redirected from.
+func redirectToPage(w http.ResponseWriter, r *vRequest, target, from string) {
+	AddAlert(fmt.Sprintf("Redirected from %s.", from), "info", r)
+	r.Session.Save(r.Request, w)
+
+	u := url.URL{Path: "/" + target}
+	http.Redirect(w, r.Request, u.String(), http.StatusFound)
+}
+
+// listLinkablePages returns the pages of the wiki and the aliases found in
+// the search index; links to them are not links to new pages.
+func listLinkablePages(ac *AppContext) ([]string, error) {
+	pages, err := ac.Storage.ListPages()
+	if err != nil {
+		return nil, err
+	}
+	aliases, err := ac.Index.Aliases()
+	if err != nil {
+		log.Printf("Error listing aliases: %s\n", err)
+		return pages, nil
+	}
+	return append(pages, aliases...), nil
+}
+
+// renderPagePart renders the page called "name" found in the directory of
+// "page" or in the nearest of its parents; it's used for sidebars and
+// footers.
+func renderPagePart(ac *AppContext, branch string, page *Page, name string, pageList []string, basePath string) template.HTML {
+	dir := path.Dir(page.Path)
+	for {
+		partPath := path.Join(dir, name)
+		if partPath != page.ShortName() {
+			part, exists, err := lookupPage(ac.Storage, branch, partPath)
+			if err != nil {
+				log.Printf("Error loading %s: %s\n", partPath, err)
+				return ""
+			}
+			// encrypted parts are never shown.
+			if exists && part.Header.Encrypted {
+				return ""
+			}
+			if exists {
+				html, err := ac.RenderCache.Render(part, branchLookup(ac.Storage, branch))
+				if err == nil {
+					// the links are relative to the directory of the part
+					html = rebaseLinks(path.Dir(part.Path), html)
+					html, err = AddCSSClasses(pageList, basePath, html)
+				}
+				if err != nil {
+					log.Printf("Error rendering %s: %s\n", part, err)
+					return ""
+				}
+				return template.HTML(html)
+			}
+		}
+
+		if dir == "." || dir == "/" {
+			break
+		}
+		dir = path.Dir(dir)
+	}
+	return ""
+}
+
+// notifyChange fires the webhooks subscribed to a page change.
+func (ac *AppContext) notifyChange(event, path, oldPath string, revId RevID, sig *CommitSignature, message string) {
+	if !ac.Webhooks.Wants(event) {
+		return
+	}
+
+	diff, err := ac.Storage.CommitDiff(revId)
+	if err != nil {
+		log.Printf("Cannot get the diff of commit %s: %s\n", revId, err)
+	}
+
+	ac.Webhooks.Fire(&WebhookPayload{
+		Event:     event,
+		Path:      path,
+		OldPath:   oldPath,
+		Commit:    revId,
+		Author:    sig.Name,
+		Email:     sig.Email,
+		Message:   message,
+		Diff:      diff,
+		Timestamp: sig.When,
+	})
+}
+
+// pageTemplateName returns the name of the template chosen for the new
+// page, or the default template of its directory.
+func pageTemplateName(r *vRequest, page *Page) string {
+	if name := r.Request.URL.Query().Get("template"); name != "" {
+		return name
+	}
+	var defaults map[string]string
+	if r.Ctx.Config != nil {
+		defaults = r.Ctx.Config.DefaultTemplates
+	}
+	return DirectoryPageTemplate(defaults, path.Dir(page.Path))
+}
+
+// newPageContent returns the initial content of the new page, made from
+// the template called name.
+func newPageContent(r *vRequest, page *Page, name string) (string, error) {
+	fullname, email := LookupAuthor(r)
+	vars := PageTemplateVars{
+		Path:   page.ShortName(),
+		Author: fullname,
+		Email:  email,
+		Time:   time.Now(),
+	}
+	content, err := NewPageFromTemplate(r.Ctx.Storage, name, vars)
+	return string(content), err
+}
+
 func EditNewPage(page *Page, w http.ResponseWriter, r *vRequest) {
 	ctx := newTemplateContext(r)
 
-	ctx["content"] = template.HTML(NewPageContent)
+	templates, err := ListPageTemplates(r.Ctx.Storage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	name := pageTemplateName(r, page)
+	content, err := newPageContent(r, page, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx["content"] = string(content)
+	ctx["templates"] = templates
+	ctx["template"] = name
 	ctx["pageName"] = page.ShortName()
 	ctx["isNew"] = true
 	ctx["comment"] = ""
@@ -148,11 +441,17 @@ func EditNewPage(page *Page, w http.ResponseWriter, r *vRequest) {
 
 func EditPage(w http.ResponseWriter, r *vRequest) {
 	pagepath := getPagePath(r)
+	if readOnlyBranch(w, r, pagepath) {
+		return
+	}
 	page, _, err := r.Ctx.Storage.LookupPage(pagepath)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !decryptPage(w, r, page) {
+		return
+	}
 
 	ctx := newTemplateContext(r)
 	preview := false
@@ -184,13 +483,13 @@ func EditPage(w http.ResponseWriter, r *vRequest) {
 				return
 			}
 
-			html, err := page.RenderPreview(pageContent)
+			html, err := page.RenderPreview(r.Ctx.Storage.LookupPage, pageContent)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
 			ctx["preview"] = template.HTML(html)
-			ctx["content"] = template.HTML(content)
+			ctx["content"] = string(content)
 		} else {
 			// not showing preview
 			if comment == "" {
@@ -198,32 +497,46 @@ func EditPage(w http.ResponseWriter, r *vRequest) {
 			}
 			fullname, email := LookupAuthor(r)
 
-			// Update page RawBytes, header and content with the new data.
+			// Update page RawBytes, header and content with the new data;
+			// the form is shown again when the new content is not valid.
+			var validationErrors []string
 			if err := page.SetRawBytes([]byte(content)); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-
-			sig := &CommitSignature{
-				Name:  fullname,
-				Email: email,
-				When:  time.Now(),
+				page.Header = &PageHeader{}
+				validationErrors = []string{fmt.Sprintf("Invalid page header: %s", err)}
+			} else {
+				validationErrors = ValidatePage(r.Ctx.Validators, page)
 			}
-			err := r.Ctx.Storage.SavePage(page, sig, comment)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+			if len(validationErrors) == 0 {
+				if err := encryptPage(r, page); err != nil {
+					validationErrors = []string{err.Error()}
+				}
 			}
 
-			// index the page
-			if err = r.Ctx.Index.AddPage(page); err != nil {
-				AddAlert(fmt.Sprintf("bleve: Cannot index document %s: %s\n", page.Path, err), "warning", r)
-				log.Printf("Error indexing document %s: %s\n", page, err)
-				r.Session.Save(r.Request, w)
+			if len(validationErrors) == 0 {
+				sig := &CommitSignature{
+					Name:  fullname,
+					Email: email,
+					When:  time.Now(),
+				}
+				revId, err := r.Ctx.Storage.SavePage(page, sig, comment)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				r.Ctx.notifyChange(EventSave, page.Path, "", revId, sig, comment)
+
+				// index the page
+				if err = r.Ctx.Index.AddPage(page, r.Ctx.Storage.LookupPage); err != nil {
+					AddAlert(fmt.Sprintf("bleve: Cannot index document %s: %s\n", page.Path, err), "warning", r)
+					log.Printf("Error indexing document %s: %s\n", page, err)
+					r.Session.Save(r.Request, w)
+				}
+				reindexIncluders(r, page.Path)
+
+				http.Redirect(w, r.Request, "/"+page.ShortName(), http.StatusSeeOther)
+				return
 			}
-
-			http.Redirect(w, r.Request, "/"+page.ShortName(), http.StatusSeeOther)
-			return
+			ctx["errors"] = validationErrors
 		}
 	}
 
@@ -231,9 +544,14 @@ func EditPage(w http.ResponseWriter, r *vRequest) {
 	if !preview {
 		// If the user is editing a new page we will show the new page template
 		if len(page.RawBytes) > 0 {
-			ctx["content"] = template.HTML(page.RawBytes)
+			ctx["content"] = string(page.RawBytes)
 		} else {
-			ctx["content"] = template.HTML(NewPageContent)
+			content, err := newPageContent(r, page, pageTemplateName(r, page))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			ctx["content"] = string(content)
 		}
 	}
 	ctx["pageName"] = page.ShortName()
@@ -282,6 +600,7 @@ func ShowPageLog(w http.ResponseWriter, r *vRequest) {
 	ctx["page"] = page
 	ctx["pageName"] = page.ShortName()
 	ctx["breadcrumbs"] = getBreadcrumbs(r)
+	ctx["_xsrf"] = xsrftoken.Generate(r.Ctx.XsrfSecret, r.AuthUser.Name, "post")
 
 	var details []map[string]interface{}
 
@@ -292,6 +611,9 @@ func ShowPageLog(w http.ResponseWriter, r *vRequest) {
 		info["name"] = commitlog.Name
 		info["email"] = commitlog.Email
 		info["when"] = commitlog.When
+		info["signed"] = commitlog.Signed
+		info["verified"] = commitlog.SignatureValid
+		info["signer"] = commitlog.SignedBy
 		details = append(details, info)
 	}
 	ctx["details"] = details
@@ -299,7 +621,13 @@ func ShowPageLog(w http.ResponseWriter, r *vRequest) {
 }
 
 func ListPages(w http.ResponseWriter, r *vRequest) {
-	pages, err := r.Ctx.Storage.ListPages()
+	var pages []string
+	var err error
+	if branch := viewedBranch(r); branch != "" {
+		pages, err = r.Ctx.Storage.ListPagesAt(branch)
+	} else {
+		pages, err = r.Ctx.Storage.ListPages()
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -315,6 +643,9 @@ func ListPages(w http.ResponseWriter, r *vRequest) {
 
 func RenamePage(w http.ResponseWriter, r *vRequest) {
 	pagepath := getPagePath(r)
+	if readOnlyBranch(w, r, pagepath) {
+		return
+	}
 	page, exists, err := r.Ctx.Storage.LookupPage(pagepath)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -361,11 +692,22 @@ func RenamePage(w http.ResponseWriter, r *vRequest) {
 
 		// Rename the page here!
 		if !formError {
-			_, err = r.Ctx.Storage.RenamePage(page.Path, newname, sig, comment)
+			revId, err := r.Ctx.Storage.RenamePage(page.Path, newname, sig, comment)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+			r.Ctx.notifyChange(EventRename, newname, page.Path, revId, sig, comment)
+			reindexIncluders(r, page.Path)
+			reindexIncluders(r, newname)
+
+			if r.Request.PostFormValue("leave-redirect") != "" {
+				if err := leaveRedirect(r, page.Path, newname, sig); err != nil {
+					AddAlert(fmt.Sprintf("Cannot leave a redirect to %s: %s", newname, err), "warning", r)
+					log.Printf("Error saving the redirect %s: %s\n", page.Path, err)
+					r.Session.Save(r.Request, w)
+				}
+			}
 
 			http.Redirect(w, r.Request, "/"+newname, http.StatusSeeOther)
 		}
@@ -387,6 +729,34 @@ type SearchResult struct {
 	Highlight template.HTML
 }
 
+// leaveRedirect saves, in place of a renamed page, a page redirecting to its
+// new name.
+func leaveRedirect(r *vRequest, oldname, newname string, sig *CommitSignature) error {
+	stub, err := NewRedirectPage(oldname, ShortenPageName(newname))
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("redirect %s to %s", stub.ShortName(), stub.Header.Redirect)
+	revId, err := r.Ctx.Storage.SavePage(stub, sig, message)
+	if err != nil {
+		return err
+	}
+	r.Ctx.notifyChange(EventSave, stub.Path, "", revId, sig, message)
+	if err = r.Ctx.Index.AddPage(stub, r.Ctx.Storage.LookupPage); err != nil {
+		return err
+	}
+	reindexIncluders(r, stub.Path)
+	return nil
+}
+
+// reindexIncluders indexes again the pages including pagepath, since their
+// indexed text contains the one of pagepath.
+func reindexIncluders(r *vRequest, pagepath string) {
+	if err := r.Ctx.Index.ReindexIncluders(pagepath, r.Ctx.Storage.LookupPage); err != nil {
+		log.Printf("Error indexing the pages including %s: %s\n", pagepath, err)
+	}
+}
+
 func SearchPages(w http.ResponseWriter, r *vRequest) {
 	if r.Request.Method != "POST" {
 		http.Redirect(w, r.Request, "/index", http.StatusFound)
@@ -440,8 +810,70 @@ func SearchPages(w http.ResponseWriter, r *vRequest) {
 	r.Ctx.RenderTemplate("results.html", ctx, w)
 }
 
+// tagCloudEntry is a tag of the tag cloud; Weight goes from 1 (the least used
+// tags) to tagCloudWeights (the most used ones).
+type tagCloudEntry struct {
+	TagCount
+	Weight int
+}
+
+const tagCloudWeights = 5
+
+func tagCloud(tags []TagCount) []tagCloudEntry {
+	min, max := 0, 0
+	for i, tag := range tags {
+		if i == 0 || tag.Count < min {
+			min = tag.Count
+		}
+		if tag.Count > max {
+			max = tag.Count
+		}
+	}
+
+	result := make([]tagCloudEntry, len(tags))
+	for i, tag := range tags {
+		weight := 1
+		if max > min {
+			weight += (tag.Count - min) * (tagCloudWeights - 1) / (max - min)
+		}
+		result[i] = tagCloudEntry{tag, weight}
+	}
+	return result
+}
+
+// ListTags shows every tag of the wiki or, with the "tag" parameter, the
+// pages tagged with it.
+func ListTags(w http.ResponseWriter, r *vRequest) {
+	ctx := newTemplateContext(r)
+	ctx["breadcrumbs"] = getBreadcrumbs(r)
+
+	if tag := r.Request.URL.Query().Get("tag"); tag != "" {
+		pages, err := r.Ctx.Index.PagesWithTag(tag)
+		if err != nil {
+			log.Printf("Error searching pages tagged %s: %s\n", tag, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ctx["tag"] = tag
+		ctx["pages"] = pages
+	} else {
+		tags, err := r.Ctx.Index.Tags()
+		if err != nil {
+			log.Printf("Error listing tags: %s\n", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ctx["tags"] = tagCloud(tags)
+	}
+
+	r.Ctx.RenderTemplate("tags.html", ctx, w)
+}
+
 func DeletePage(w http.ResponseWriter, r *vRequest) {
 	pagepath := getPagePath(r)
+	if readOnlyBranch(w, r, pagepath) {
+		return
+	}
 	page, exists, err := r.Ctx.Storage.LookupPage(pagepath)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -475,17 +907,19 @@ func DeletePage(w http.ResponseWriter, r *vRequest) {
 			When:  time.Now(),
 		}
 
-		_, err := r.Ctx.Storage.DeletePage(page.Path, sig, comment)
+		revId, err := r.Ctx.Storage.DeletePage(page.Path, sig, comment)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		r.Ctx.notifyChange(EventDelete, page.Path, "", revId, sig, comment)
 
 		if err = r.Ctx.Index.DeletePage(page); err != nil {
 			AddAlert(fmt.Sprintf("bleve: Cannot delete document %s from index: %s\n", page.Path, err), "warning", r)
 			log.Printf("Error removing document %s from index: %s\n", page, err)
 			r.Session.Save(r.Request, w)
 		}
+		reindexIncluders(r, page.Path)
 
 		http.Redirect(w, r.Request, "/index", http.StatusSeeOther)
 		return
@@ -561,3 +995,204 @@ func IndexAllPages(w http.ResponseWriter, r *vRequest) {
 	}
 	http.Redirect(w, r.Request, url.Path, http.StatusFound)
 }
+
+// downloadWriter sets the headers of a file download on the first Write,
+// so that errors happening before sending any data can still be reported
+// with http.Error.
+type downloadWriter struct {
+	w           http.ResponseWriter
+	filename    string
+	contentType string
+	started     bool
+}
+
+func (dw *downloadWriter) Write(p []byte) (int, error) {
+	if !dw.started {
+		dw.started = true
+		dw.w.Header().Set("Content-Type", dw.contentType)
+		dw.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", dw.filename))
+	}
+	return dw.w.Write(p)
+}
+
+// ArchivePages streams an archive of a wiki directory, or of the whole wiki,
+// at the last commit or at the revision specified in the "rev" parameter.
+func ArchivePages(w http.ResponseWriter, r *vRequest) {
+	dir := strings.Trim(getPagePath(r), "/")
+	format := r.Request.URL.Query().Get("format")
+	rev := r.Request.URL.Query().Get("rev")
+
+	var contentType string
+	switch format {
+	case ArchiveZip:
+		contentType = "application/zip"
+	case ArchiveTarGz:
+		contentType = "application/gzip"
+	default:
+		http.Error(w, "Invalid archive format", http.StatusBadRequest)
+		return
+	}
+
+	if rev != "" && !revisionRe.MatchString(rev) {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	name := "wiki"
+	if dir != "" {
+		name = path.Base(dir)
+	}
+	if rev != "" {
+		name += "-" + rev[:7]
+	}
+
+	dw := &downloadWriter{w: w, filename: name + "." + format, contentType: contentType}
+	if err := WriteArchive(dw, r.Ctx.Storage, format, dir, rev, name); err != nil {
+		if !dw.started {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error writing archive of \"%s\": %s\n", dir, err)
+	}
+}
+
+// ShowTrash lists the pages deleted from the wiki.
+func ShowTrash(w http.ResponseWriter, r *vRequest) {
+	deleted, err := r.Ctx.Storage.DeletedPages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx := newTemplateContext(r)
+	ctx["deleted"] = deleted
+	ctx["breadcrumbs"] = getBreadcrumbs(r)
+	ctx["alerts"] = GetAlerts(r, w)
+	ctx["_xsrf"] = xsrftoken.Generate(r.Ctx.XsrfSecret, r.AuthUser.Name, "post")
+
+	r.Ctx.RenderTemplate("trash.html", ctx, w)
+}
+
+// UndeletePage restores a page listed in the trash and indexes it again.
+func UndeletePage(w http.ResponseWriter, r *vRequest) {
+	if r.Request.Method != "POST" {
+		http.Redirect(w, r.Request, "/?action=trash", http.StatusFound)
+		return
+	}
+
+	if err := r.Request.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	xsrf := r.Request.PostFormValue("_xsrf")
+	if xsrfValid := xsrftoken.Valid(xsrf, r.Ctx.XsrfSecret, r.AuthUser.Name, "post"); !xsrfValid {
+		http.Error(w, "Invalid XSRF token", http.StatusBadRequest)
+		return
+	}
+
+	filename := r.Request.PostFormValue("path")
+	rev := r.Request.PostFormValue("rev")
+	if filename == "" || !revisionRe.MatchString(rev) {
+		http.Error(w, "Invalid parameters", http.StatusBadRequest)
+		return
+	}
+
+	fullname, email := LookupAuthor(r)
+	sig := &CommitSignature{
+		Name:  fullname,
+		Email: email,
+		When:  time.Now(),
+	}
+	comment := fmt.Sprintf("restored %s", ShortenPageName(filename))
+
+	revId, err := r.Ctx.Storage.RestorePage(filename, rev, sig, comment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	r.Ctx.notifyChange(EventRestore, filename, "", revId, sig, comment)
+
+	page, _, err := r.Ctx.Storage.LookupPage(ShortenPageName(filename))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err = r.Ctx.Index.AddPage(page, r.Ctx.Storage.LookupPage); err != nil {
+		AddAlert(fmt.Sprintf("bleve: Cannot index document %s: %s\n", page.Path, err), "warning", r)
+		log.Printf("Error indexing document %s: %s\n", page, err)
+	}
+	reindexIncluders(r, page.Path)
+	AddAlert(fmt.Sprintf("Page %s restored", page.ShortName()), "success", r)
+	r.Session.Save(r.Request, w)
+
+	http.Redirect(w, r.Request, "/"+page.ShortName(), http.StatusSeeOther)
+}
+
+// RevertPage restores the content that a page had at an older revision.
+func RevertPage(w http.ResponseWriter, r *vRequest) {
+	pagepath := getPagePath(r)
+	if readOnlyBranch(w, r, pagepath) {
+		return
+	}
+	page, exists, err := r.Ctx.Storage.LookupPage(pagepath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if !exists {
+		http.NotFound(w, r.Request)
+		return
+	}
+
+	if r.Request.Method != "POST" {
+		http.Redirect(w, r.Request, "/"+page.ShortName()+"?action=log", http.StatusFound)
+		return
+	}
+
+	if err = r.Request.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	xsrf := r.Request.PostFormValue("_xsrf")
+	if xsrfValid := xsrftoken.Valid(xsrf, r.Ctx.XsrfSecret, r.AuthUser.Name, "post"); !xsrfValid {
+		http.Error(w, "Invalid XSRF token", http.StatusBadRequest)
+		return
+	}
+
+	rev := r.Request.FormValue("rev")
+	if !revisionRe.MatchString(rev) {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	fullname, email := LookupAuthor(r)
+	sig := &CommitSignature{
+		Name:  fullname,
+		Email: email,
+		When:  time.Now(),
+	}
+	comment := fmt.Sprintf("reverted %s to %s", page.ShortName(), rev[:7])
+
+	revId, err := r.Ctx.Storage.RevertPage(page.Path, rev, sig, comment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	r.Ctx.notifyChange(EventRevert, page.Path, "", revId, sig, comment)
+
+	if page, _, err = r.Ctx.Storage.LookupPage(pagepath); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = r.Ctx.Index.AddPage(page, r.Ctx.Storage.LookupPage); err != nil {
+		AddAlert(fmt.Sprintf("bleve: Cannot index document %s: %s\n", page.Path, err), "warning", r)
+		log.Printf("Error indexing document %s: %s\n", page, err)
+	}
+	reindexIncluders(r, page.Path)
+	AddAlert(fmt.Sprintf("Page reverted to %s", rev[:7]), "success", r)
+	r.Session.Save(r.Request, w)
+
+	http.Redirect(w, r.Request, "/"+page.ShortName(), http.StatusSeeOther)
+}
@@ -0,0 +1,78 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// Redirects: a page whose header contains "redirect: new/path" sends the
+// readers to new/path, and the "aliases" of a page are other paths
+// resolving to it.
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// MaxRedirects is the maximum number of redirects followed to reach a page.
+const MaxRedirects = 5
+
+// redirectTarget returns the path of the page the redirect of page points
+// to; like wiki links, it's relative to the directory of page.
+func redirectTarget(page *Page) string {
+	return wikiPagePath(path.Dir(page.Path), page.Header.Redirect)
+}
+
+// ResolveRedirect follows the redirects starting from page and returns the
+// path of the final page, which may not exist; an error is returned on
+// redirect loops.
+func ResolveRedirect(lookup LookupFunc, page *Page) (string, error) {
+	visited := []string{page.ShortName()}
+	for page.Header.Redirect != "" {
+		target := redirectTarget(page)
+		for _, p := range visited {
+			if p == target {
+				return "", fmt.Errorf("redirect loop: %s -> %s", strings.Join(visited, " -> "), target)
+			}
+		}
+		if len(visited) > MaxRedirects {
+			return "", fmt.Errorf("more than %d redirects", MaxRedirects)
+		}
+		visited = append(visited, target)
+
+		var exists bool
+		var err error
+		if page, exists, err = lookup(target); err != nil {
+			return "", err
+		} else if !exists {
+			break
+		}
+	}
+	return visited[len(visited)-1], nil
+}
+
+// NewRedirectPage returns a page, stored at pagepath (a filename), which
+// redirects to target.
+func NewRedirectPage(pagepath, target string) (*Page, error) {
+	content, err := FormatPageBytes(&PageHeader{Redirect: "/" + cleanTreePath(target)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	page := NewPage(pagepath)
+	if err := page.SetRawBytes(content); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// aliasPaths returns the aliases of a page header as paths relative to the
+// root of the wiki, without the page extension.
+func aliasPaths(header *PageHeader) []string {
+	var result []string
+	for _, alias := range header.Aliases {
+		if alias = wikiPagePath("", alias); alias != "" {
+			result = append(result, alias)
+		}
+	}
+	return result
+}
@@ -0,0 +1,59 @@
+package spock
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveRedirect(t *testing.T) {
+	lookup := mapLookup(t, map[string]string{
+		"old/name.md":  "---\nredirect: ../new/name\n---\n",
+		"new/name.md":  "---\nredirect: /final\n---\n",
+		"final.md":     "# Final",
+		"loop/a.md":    "---\nredirect: b\n---\n",
+		"loop/b.md":    "---\nredirect: /loop/a\n---\n",
+		"dangling.rst": "---\nredirect: missing\n---\n",
+	})
+
+	checks := map[string]string{
+		"old/name": "final",
+		"final":    "final",
+		"dangling": "missing",
+	}
+	for pagepath, expected := range checks {
+		page, _, err := lookup(pagepath)
+		checkFatal(t, err)
+		target, err := ResolveRedirect(lookup, page)
+		checkFatal(t, err)
+		if target != expected {
+			t.Errorf("%s should redirect to %q, got %q", pagepath, expected, target)
+		}
+	}
+
+	page, _, err := lookup("loop/a")
+	checkFatal(t, err)
+	_, err = ResolveRedirect(lookup, page)
+	if err == nil || !strings.Contains(err.Error(), "redirect loop: loop/a -> loop/b -> loop/a") {
+		t.Errorf("expected a redirect loop error, got %v", err)
+	}
+}
+
+func TestNewRedirectPage(t *testing.T) {
+	page, err := NewRedirectPage("notes/old.md", "notes/new")
+	checkFatal(t, err)
+	if page.Header.Redirect != "/notes/new" {
+		t.Errorf("unexpected redirect %q", page.Header.Redirect)
+	}
+	if expected := "---\nredirect: /notes/new\n---\n"; string(page.RawBytes) != expected {
+		t.Errorf("expected %q, got %q", expected, page.RawBytes)
+	}
+}
+
+func TestAliasPaths(t *testing.T) {
+	header := &PageHeader{Aliases: []string{"/runbooks/disk.md", "Disk Full", "../x", ""}}
+	expected := []string{"runbooks/disk", "Disk-Full", "x"}
+	if result := aliasPaths(header); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
@@ -0,0 +1,889 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// A reStructuredText renderer for the subset of the markup commonly used in
+// wiki pages: sections, paragraphs, bullet, enumerated, definition and
+// field lists, literal blocks, block quotes, grid and simple tables,
+// transitions, inline markup, hyperlinks and the code, image and
+// admonition directives.
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type rstKind int
+
+const (
+	rstSection rstKind = iota
+	rstParagraph
+	rstLiteral
+	rstCode
+	rstBulletList
+	rstEnumList
+	rstDefList
+	rstFieldList
+	rstQuote
+	rstAdmonition
+	rstImage
+	rstTable
+	rstTransition
+)
+
+// rstNode is a block of a reStructuredText document.
+type rstNode struct {
+	kind rstKind
+	// inline text of sections, paragraphs, terms and field names; content
+	// of literal and code blocks.
+	text string
+	// section level, starting from 1.
+	level int
+	// language of code blocks, class of admonitions.
+	class string
+	// title of admonitions, alternate text of images.
+	title string
+	// image URL.
+	src string
+	// list items, definitions, quote and admonition content.
+	children []*rstNode
+	// table cells; the first "header" rows are the table header.
+	rows   [][]string
+	header int
+	// first number of enumerated lists.
+	start int
+}
+
+// rstDocument holds the state shared by the nested blocks of a document.
+type rstDocument struct {
+	// hyperlink targets, by normalized name.
+	targets map[string]string
+	// section adornment styles, in order of appearance.
+	styles []string
+}
+
+var (
+	rstTargetRe     = regexp.MustCompile(`^\s*\.\. _([^:]+):\s*(\S*)\s*$`)
+	rstDirectiveRe  = regexp.MustCompile(`^\.\.\s+([\w-]+)::\s*(.*)$`)
+	rstOptionRe     = regexp.MustCompile(`^:([\w-]+):\s*(.*)$`)
+	rstBulletRe     = regexp.MustCompile(`^([-*+])( +)(.*)$`)
+	rstEnumRe       = regexp.MustCompile(`^(\d+|#|[a-zA-Z])([.)])( +)(.*)$`)
+	rstEnumParenRe  = regexp.MustCompile(`^\((\d+|#|[a-zA-Z])\)( +)(.*)$`)
+	rstFieldRe      = regexp.MustCompile(`^:([^:]+):(?:\s+(.*))?$`)
+	rstGridBorderRe = regexp.MustCompile(`^\+([-=]+\+)+$`)
+	rstSimpleRe     = regexp.MustCompile(`^=+( +=+)+$`)
+	rstSimpleColRe  = regexp.MustCompile(`=+`)
+)
+
+var rstAdmonitions = map[string]string{
+	"attention": "Attention",
+	"caution":   "Caution",
+	"danger":    "Danger",
+	"error":     "Error",
+	"hint":      "Hint",
+	"important": "Important",
+	"note":      "Note",
+	"tip":       "Tip",
+	"warning":   "Warning",
+	"seealso":   "See also",
+}
+
+// renderRstNative renders a reStructuredText document as HTML.
+func renderRstNative(content []byte) ([]byte, error) {
+	doc, nodes := parseRst(content)
+	var buf bytes.Buffer
+	doc.writeHTML(&buf, nodes)
+	return buf.Bytes(), nil
+}
+
+// renderRstNativePlain returns the text of a reStructuredText document,
+// without the markup.
+func renderRstNativePlain(content []byte) ([]byte, error) {
+	doc, nodes := parseRst(content)
+	var buf bytes.Buffer
+	doc.writeText(&buf, nodes)
+	return buf.Bytes(), nil
+}
+
+func parseRst(content []byte) (*rstDocument, []*rstNode) {
+	lines := splitLines(string(content))
+	doc := &rstDocument{targets: make(map[string]string)}
+	for _, line := range lines {
+		if m := rstTargetRe.FindStringSubmatch(line); m != nil {
+			doc.targets[rstNormalizeName(m[1])] = m[2]
+		}
+	}
+	return doc, doc.parseBlocks(lines)
+}
+
+// splitLines splits a text into lines, expanding tabs and removing the
+// trailing spaces.
+func splitLines(text string) []string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if strings.Contains(line, "\t") {
+			line = expandTabs(line)
+		}
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return lines
+}
+
+func expandTabs(line string) string {
+	var buf bytes.Buffer
+	col := 0
+	for _, r := range line {
+		if r == '\t' {
+			n := 8 - col%8
+			buf.WriteString(strings.Repeat(" ", n))
+			col += n
+		} else {
+			buf.WriteRune(r)
+			col++
+		}
+	}
+	return buf.String()
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// dedentLines removes the common indentation of the lines.
+func dedentLines(lines []string) []string {
+	min := -1
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		if indent := indentOf(line); min == -1 || indent < min {
+			min = indent
+		}
+	}
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		if len(line) >= min && min > 0 {
+			result[i] = line[min:]
+		} else {
+			result[i] = strings.TrimLeft(line, " ")
+		}
+	}
+	return result
+}
+
+func trimBlankLines(lines []string) []string {
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// indentedBlock returns the lines starting at i that are blank or
+// indented, and the index of the first line after them.
+func indentedBlock(lines []string, i int) ([]string, int) {
+	start := i
+	for i < len(lines) && (lines[i] == "" || indentOf(lines[i]) > 0) {
+		i++
+	}
+	return trimBlankLines(lines[start:i]), i
+}
+
+// isRstAdornment returns true if line is made of a repeated punctuation
+// character, like the underline of a section title.
+func isRstAdornment(line string) bool {
+	if len(line) < 2 || !strings.ContainsRune("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", rune(line[0])) {
+		return false
+	}
+	return strings.Count(line, line[:1]) == len(line)
+}
+
+func isBlank(lines []string, i int) bool {
+	return i < 0 || i >= len(lines) || lines[i] == ""
+}
+
+func rstNormalizeName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+func (doc *rstDocument) sectionLevel(style string) int {
+	for i, s := range doc.styles {
+		if s == style {
+			return i + 1
+		}
+	}
+	doc.styles = append(doc.styles, style)
+	return len(doc.styles)
+}
+
+func (doc *rstDocument) parseBlocks(lines []string) []*rstNode {
+	var nodes []*rstNode
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if line == "" {
+			i++
+			continue
+		}
+
+		// block quote
+		if indentOf(line) > 0 {
+			block, next := indentedBlock(lines, i)
+			nodes = append(nodes, &rstNode{kind: rstQuote, children: doc.parseBlocks(dedentLines(block))})
+			i = next
+			continue
+		}
+
+		// section with overline
+		if isRstAdornment(line) && i+2 < len(lines) && lines[i+2] == line && lines[i+1] != "" {
+			title := strings.TrimSpace(lines[i+1])
+			level := doc.sectionLevel("o" + line[:1])
+			nodes = append(nodes, &rstNode{kind: rstSection, text: title, level: level})
+			i += 3
+			continue
+		}
+
+		// transition
+		if isRstAdornment(line) && len(line) >= 4 && isBlank(lines, i-1) && isBlank(lines, i+1) {
+			nodes = append(nodes, &rstNode{kind: rstTransition})
+			i++
+			continue
+		}
+
+		// section with underline
+		if i+1 < len(lines) && isRstAdornment(lines[i+1]) &&
+			len(lines[i+1]) >= utf8.RuneCountInString(line) && !rstGridBorderRe.MatchString(line) {
+			level := doc.sectionLevel(lines[i+1][:1])
+			nodes = append(nodes, &rstNode{kind: rstSection, text: line, level: level})
+			i += 2
+			continue
+		}
+
+		if line == ".." || strings.HasPrefix(line, ".. ") {
+			var node *rstNode
+			node, i = doc.parseExplicit(lines, i)
+			if node != nil {
+				nodes = append(nodes, node)
+			}
+			continue
+		}
+
+		if rstGridBorderRe.MatchString(line) {
+			var node *rstNode
+			node, i = parseGridTable(lines, i)
+			nodes = append(nodes, node)
+			continue
+		}
+
+		if rstSimpleRe.MatchString(line) {
+			var node *rstNode
+			node, i = parseSimpleTable(lines, i)
+			nodes = append(nodes, node)
+			continue
+		}
+
+		if rstBulletRe.MatchString(line) {
+			var node *rstNode
+			node, i = doc.parseBulletList(lines, i)
+			nodes = append(nodes, node)
+			continue
+		}
+
+		if isRstEnumItem(line) {
+			var node *rstNode
+			node, i = doc.parseEnumList(lines, i)
+			nodes = append(nodes, node)
+			continue
+		}
+
+		if rstFieldRe.MatchString(line) {
+			var node *rstNode
+			node, i = doc.parseFieldList(lines, i)
+			nodes = append(nodes, node)
+			continue
+		}
+
+		// definition list: a term followed by an indented definition
+		if i+1 < len(lines) && lines[i+1] != "" && indentOf(lines[i+1]) > 0 {
+			var node *rstNode
+			node, i = doc.parseDefList(lines, i)
+			nodes = append(nodes, node)
+			continue
+		}
+
+		// paragraph, possibly introducing a literal block
+		start := i
+		for i < len(lines) && lines[i] != "" && indentOf(lines[i]) == 0 {
+			i++
+		}
+		text := strings.Join(lines[start:i], "\n")
+		literal := strings.HasSuffix(text, "::")
+		if literal {
+			if text == "::" {
+				text = ""
+			} else if strings.HasSuffix(text, " ::") {
+				text = strings.TrimSuffix(text, " ::")
+			} else {
+				text = strings.TrimSuffix(text, ":")
+			}
+		}
+		if text != "" {
+			nodes = append(nodes, &rstNode{kind: rstParagraph, text: text})
+		}
+		if literal {
+			for i < len(lines) && lines[i] == "" {
+				i++
+			}
+			var block []string
+			block, i = indentedBlock(lines, i)
+			if len(block) > 0 {
+				nodes = append(nodes, &rstNode{kind: rstLiteral, text: strings.Join(dedentLines(block), "\n")})
+			}
+		}
+	}
+
+	return nodes
+}
+
+// parseExplicit parses the explicit markup blocks: directives, comments
+// and hyperlink targets.
+func (doc *rstDocument) parseExplicit(lines []string, i int) (*rstNode, int) {
+	line := lines[i]
+	block, next := indentedBlock(lines, i+1)
+
+	m := rstDirectiveRe.FindStringSubmatch(line)
+	if m == nil {
+		// comments and hyperlink targets are not rendered
+		return nil, next
+	}
+	name, arg := strings.ToLower(m[1]), strings.TrimSpace(m[2])
+
+	block = dedentLines(block)
+	options := make(map[string]string)
+	for len(block) > 0 {
+		o := rstOptionRe.FindStringSubmatch(block[0])
+		if o == nil {
+			break
+		}
+		options[o[1]] = o[2]
+		block = block[1:]
+	}
+	block = trimBlankLines(block)
+
+	switch name {
+	case "code-block", "code", "sourcecode":
+		return &rstNode{kind: rstCode, class: arg, text: strings.Join(block, "\n")}, next
+	case "image", "figure":
+		node := &rstNode{kind: rstImage, src: arg, title: options["alt"]}
+		if name == "figure" && len(block) > 0 {
+			node.children = doc.parseBlocks(block)
+		}
+		return node, next
+	case "admonition":
+		return &rstNode{kind: rstAdmonition, class: "admonition", title: arg, children: doc.parseBlocks(block)}, next
+	}
+
+	if title, ok := rstAdmonitions[name]; ok {
+		// the directive argument is the beginning of the content
+		if arg != "" && isBlank(lines, i+1) {
+			block = append([]string{arg, ""}, block...)
+		} else if arg != "" {
+			block = append([]string{arg}, block...)
+		}
+		return &rstNode{kind: rstAdmonition, class: name, title: title, children: doc.parseBlocks(block)}, next
+	}
+
+	// unknown directives (e.g. "contents") are ignored
+	return nil, next
+}
+
+// listItem returns the content of a list item whose text begins at column
+// width of line i, and the index of the line after the item.
+func listItem(lines []string, i, width int, first string) ([]string, int) {
+	item := []string{first}
+	i++
+	for i < len(lines) && (lines[i] == "" || indentOf(lines[i]) >= width) {
+		if lines[i] == "" {
+			item = append(item, "")
+		} else {
+			item = append(item, lines[i][width:])
+		}
+		i++
+	}
+	// the blank lines after the item separate it from the next one
+	for len(item) > 0 && item[len(item)-1] == "" {
+		item = item[:len(item)-1]
+	}
+	return item, i
+}
+
+func (doc *rstDocument) parseBulletList(lines []string, i int) (*rstNode, int) {
+	node := &rstNode{kind: rstBulletList}
+	bullet := lines[i][:1]
+
+	for i < len(lines) {
+		m := rstBulletRe.FindStringSubmatch(lines[i])
+		if m == nil || m[1] != bullet {
+			break
+		}
+		var item []string
+		item, i = listItem(lines, i, len(m[1])+len(m[2]), m[3])
+		node.children = append(node.children, &rstNode{kind: rstParagraph, children: doc.parseBlocks(item)})
+
+		// skip the blank lines between the items
+		j := i
+		for j < len(lines) && lines[j] == "" {
+			j++
+		}
+		if j < len(lines) && rstBulletRe.MatchString(lines[j]) {
+			i = j
+		}
+	}
+	return node, i
+}
+
+func isRstEnumItem(line string) bool {
+	return rstEnumRe.MatchString(line) || rstEnumParenRe.MatchString(line)
+}
+
+// parseRstEnum returns the enumerator, the width of the marker and the text
+// of an enumerated list item.
+func parseRstEnum(line string) (string, int, string) {
+	if m := rstEnumParenRe.FindStringSubmatch(line); m != nil {
+		return m[1], len(m[1]) + 2 + len(m[2]), m[3]
+	}
+	m := rstEnumRe.FindStringSubmatch(line)
+	return m[1], len(m[1]) + 1 + len(m[3]), m[4]
+}
+
+func (doc *rstDocument) parseEnumList(lines []string, i int) (*rstNode, int) {
+	node := &rstNode{kind: rstEnumList, start: 1}
+
+	enum, _, _ := parseRstEnum(lines[i])
+	if n, err := strconv.Atoi(enum); err == nil {
+		node.start = n
+	}
+
+	for i < len(lines) && isRstEnumItem(lines[i]) {
+		_, width, text := parseRstEnum(lines[i])
+		var item []string
+		item, i = listItem(lines, i, width, text)
+		node.children = append(node.children, &rstNode{kind: rstParagraph, children: doc.parseBlocks(item)})
+
+		j := i
+		for j < len(lines) && lines[j] == "" {
+			j++
+		}
+		if j < len(lines) && isRstEnumItem(lines[j]) {
+			i = j
+		}
+	}
+	return node, i
+}
+
+func (doc *rstDocument) parseDefList(lines []string, i int) (*rstNode, int) {
+	node := &rstNode{kind: rstDefList}
+
+	for i+1 < len(lines) && lines[i] != "" && indentOf(lines[i]) == 0 && lines[i+1] != "" && indentOf(lines[i+1]) > 0 {
+		term := lines[i]
+		var block []string
+		block, i = indentedBlock(lines, i+1)
+		node.children = append(node.children, &rstNode{kind: rstParagraph, text: term, children: doc.parseBlocks(dedentLines(block))})
+
+		j := i
+		for j < len(lines) && lines[j] == "" {
+			j++
+		}
+		i = j
+	}
+	return node, i
+}
+
+func (doc *rstDocument) parseFieldList(lines []string, i int) (*rstNode, int) {
+	node := &rstNode{kind: rstFieldList}
+
+	for i < len(lines) {
+		m := rstFieldRe.FindStringSubmatch(lines[i])
+		if m == nil {
+			break
+		}
+		body := []string{m[2]}
+		var block []string
+		block, i = indentedBlock(lines, i+1)
+		body = append(body, dedentLines(block)...)
+		node.children = append(node.children, &rstNode{kind: rstParagraph, text: m[1], children: doc.parseBlocks(trimBlankLines(body))})
+
+		for i < len(lines) && lines[i] == "" && i+1 < len(lines) && rstFieldRe.MatchString(lines[i+1]) {
+			i++
+		}
+	}
+	return node, i
+}
+
+// parseGridTable parses a table drawn with "+", "-", "=" and "|"; cells
+// spanning more than one column are not supported. The columns are counted
+// in runes, since the borders are ASCII but the cells may not be.
+func parseGridTable(lines []string, i int) (*rstNode, int) {
+	node := &rstNode{kind: rstTable}
+
+	border := lines[i]
+	var cols []int
+	for pos, r := range border {
+		if r == '+' {
+			cols = append(cols, pos)
+		}
+	}
+
+	var row []string
+	i++
+	for i < len(lines) && (strings.HasPrefix(lines[i], "|") || strings.HasPrefix(lines[i], "+")) {
+		line := lines[i]
+		i++
+		if rstGridBorderRe.MatchString(line) {
+			if row != nil {
+				node.rows = append(node.rows, row)
+				row = nil
+			}
+			if strings.Contains(line, "=") {
+				node.header = len(node.rows)
+			}
+			continue
+		}
+
+		if row == nil {
+			row = make([]string, len(cols)-1)
+		}
+		runes := []rune(line)
+		for c := 0; c+1 < len(cols); c++ {
+			start, end := cols[c]+1, cols[c+1]
+			if start >= len(runes) {
+				break
+			}
+			if end > len(runes) {
+				end = len(runes)
+			}
+			cell := strings.TrimSpace(string(runes[start:end]))
+			if cell == "" {
+				continue
+			}
+			if row[c] != "" {
+				row[c] += "\n"
+			}
+			row[c] += cell
+		}
+	}
+	return node, i
+}
+
+// parseSimpleTable parses a table whose columns are delimited by lines of
+// "=" characters; like in grid tables, the columns are counted in runes.
+func parseSimpleTable(lines []string, i int) (*rstNode, int) {
+	node := &rstNode{kind: rstTable}
+
+	border := lines[i]
+	var cols [][2]int
+	for _, loc := range rstSimpleColRe.FindAllStringIndex(border, -1) {
+		cols = append(cols, [2]int{loc[0], loc[1]})
+	}
+
+	cell := func(line string, c int) string {
+		runes := []rune(line)
+		start := cols[c][0]
+		end := len(runes)
+		if c+1 < len(cols) && cols[c+1][0] < end {
+			end = cols[c+1][0]
+		}
+		if start >= end {
+			return ""
+		}
+		return strings.TrimSpace(string(runes[start:end]))
+	}
+
+	i++
+	for i < len(lines) {
+		line := lines[i]
+		i++
+		if rstSimpleRe.MatchString(line) {
+			// the table ends with a border followed by a blank line
+			if isBlank(lines, i) {
+				break
+			}
+			node.header = len(node.rows)
+			continue
+		}
+		if line == "" {
+			continue
+		}
+
+		// a blank first column continues the previous row
+		if len(node.rows) > 0 && cell(line, 0) == "" {
+			prev := node.rows[len(node.rows)-1]
+			for c := range cols {
+				if text := cell(line, c); text != "" {
+					prev[c] += "\n" + text
+				}
+			}
+			continue
+		}
+
+		row := make([]string, len(cols))
+		for c := range cols {
+			row[c] = cell(line, c)
+		}
+		node.rows = append(node.rows, row)
+	}
+	return node, i
+}
+
+// Inline markup.
+var rstInlineRe = regexp.MustCompile(
+	"``(.+?)``" + // 1: literal
+		`|\*\*(\S(?:.*?\S)?)\*\*` + // 2: strong
+		`|\*(\S(?:[^*]*?\S)?)\*` + // 3: emphasis
+		"|`([^`]*?)\\s*<([^<>`]+)>`__?" + // 4, 5: hyperlink with URL
+		"|:([\\w-]+):`([^`]+)`" + // 6, 7: role
+		"|`([^`]+)`(__?)?" + // 8, 9: reference or title
+		`|\b([A-Za-z0-9](?:[\w.-]*[A-Za-z0-9])?)__?\b` + // 10: simple reference
+		`|(https?://[^\s<>"]*[^\s<>".,;:!?)'])`) // 11: URL
+
+// rstInlineStart returns true if the inline markup can start after r.
+func rstInlineStart(r rune) bool {
+	return r == ' ' || r == '\n' || strings.ContainsRune(`-:/'"<([{`, r)
+}
+
+// inline renders the inline markup of text, as HTML or as plain text.
+func (doc *rstDocument) inline(text string, plain bool) string {
+	esc := html.EscapeString
+	if plain {
+		esc = func(s string) string { return s }
+	}
+	link := func(label, href string) string {
+		if plain {
+			return label
+		}
+		return fmt.Sprintf(`<a href="%s">%s</a>`, esc(href), esc(label))
+	}
+	tag := func(name, content string) string {
+		if plain {
+			return content
+		}
+		return "<" + name + ">" + esc(content) + "</" + name + ">"
+	}
+
+	var buf bytes.Buffer
+	last := 0
+	for _, m := range rstInlineRe.FindAllStringSubmatchIndex(text, -1) {
+		if m[0] > 0 {
+			if r, _ := utf8.DecodeLastRuneInString(text[:m[0]]); !rstInlineStart(r) {
+				continue
+			}
+		}
+		group := func(n int) string {
+			if m[2*n] == -1 {
+				return ""
+			}
+			return text[m[2*n]:m[2*n+1]]
+		}
+
+		var out string
+		switch {
+		case m[2] != -1:
+			out = tag("code", group(1))
+		case m[4] != -1:
+			out = tag("strong", group(2))
+		case m[6] != -1:
+			out = tag("em", group(3))
+		case m[10] != -1:
+			label, href := group(4), group(5)
+			if label == "" {
+				label = href
+			} else {
+				doc.targets[rstNormalizeName(label)] = href
+			}
+			out = link(label, href)
+		case m[12] != -1:
+			switch group(6) {
+			case "code", "literal", "file", "command", "kbd":
+				out = tag("code", group(7))
+			case "strong":
+				out = tag("strong", group(7))
+			case "emphasis", "title-reference":
+				out = tag("em", group(7))
+			default:
+				out = esc(group(7))
+			}
+		case m[16] != -1:
+			name := group(8)
+			if group(9) == "" {
+				out = tag("cite", name)
+			} else if href, ok := doc.targets[rstNormalizeName(name)]; ok {
+				out = link(name, href)
+			} else {
+				out = esc(name)
+			}
+		case m[20] != -1:
+			name := group(10)
+			href, ok := doc.targets[rstNormalizeName(name)]
+			if !ok {
+				// not a reference, e.g. an identifier ending with "_"
+				continue
+			}
+			out = link(name, href)
+		case m[22] != -1:
+			out = link(group(11), group(11))
+		}
+
+		buf.WriteString(esc(text[last:m[0]]))
+		buf.WriteString(out)
+		last = m[1]
+	}
+	buf.WriteString(esc(text[last:]))
+	return buf.String()
+}
+
+func (doc *rstDocument) writeHTML(buf *bytes.Buffer, nodes []*rstNode) {
+	for _, node := range nodes {
+		switch node.kind {
+		case rstSection:
+			level := node.level
+			if level > 6 {
+				level = 6
+			}
+			fmt.Fprintf(buf, "<h%d>%s</h%d>\n", level, doc.inline(node.text, false), level)
+		case rstParagraph:
+			fmt.Fprintf(buf, "<p>%s</p>\n", doc.inline(node.text, false))
+		case rstLiteral:
+			fmt.Fprintf(buf, "<pre><code>%s</code></pre>\n", html.EscapeString(node.text))
+		case rstCode:
+			if node.class != "" {
+				fmt.Fprintf(buf, "<pre><code class=\"language-%s\">%s</code></pre>\n", html.EscapeString(node.class), html.EscapeString(node.text))
+			} else {
+				fmt.Fprintf(buf, "<pre><code>%s</code></pre>\n", html.EscapeString(node.text))
+			}
+		case rstBulletList, rstEnumList:
+			if node.kind == rstBulletList {
+				buf.WriteString("<ul>\n")
+			} else if node.start != 1 {
+				fmt.Fprintf(buf, "<ol start=\"%d\">\n", node.start)
+			} else {
+				buf.WriteString("<ol>\n")
+			}
+			for _, item := range node.children {
+				buf.WriteString("<li>")
+				doc.writeItem(buf, item.children)
+				buf.WriteString("</li>\n")
+			}
+			if node.kind == rstBulletList {
+				buf.WriteString("</ul>\n")
+			} else {
+				buf.WriteString("</ol>\n")
+			}
+		case rstDefList, rstFieldList:
+			if node.kind == rstFieldList {
+				buf.WriteString("<dl class=\"field-list\">\n")
+			} else {
+				buf.WriteString("<dl>\n")
+			}
+			for _, item := range node.children {
+				fmt.Fprintf(buf, "<dt>%s</dt>\n<dd>", doc.inline(item.text, false))
+				doc.writeItem(buf, item.children)
+				buf.WriteString("</dd>\n")
+			}
+			buf.WriteString("</dl>\n")
+		case rstQuote:
+			buf.WriteString("<blockquote>\n")
+			doc.writeHTML(buf, node.children)
+			buf.WriteString("</blockquote>\n")
+		case rstAdmonition:
+			fmt.Fprintf(buf, "<div class=\"admonition %s\">\n<p class=\"admonition-title\">%s</p>\n", html.EscapeString(node.class), doc.inline(node.title, false))
+			doc.writeHTML(buf, node.children)
+			buf.WriteString("</div>\n")
+		case rstImage:
+			fmt.Fprintf(buf, "<img src=\"%s\" alt=\"%s\">\n", html.EscapeString(node.src), html.EscapeString(node.title))
+			doc.writeHTML(buf, node.children)
+		case rstTable:
+			buf.WriteString("<table class=\"table\">\n")
+			for r, row := range node.rows {
+				cellTag := "td"
+				if r < node.header {
+					cellTag = "th"
+				}
+				buf.WriteString("<tr>")
+				for _, cell := range row {
+					fmt.Fprintf(buf, "<%s>%s</%s>", cellTag, doc.inline(cell, false), cellTag)
+				}
+				buf.WriteString("</tr>\n")
+			}
+			buf.WriteString("</table>\n")
+		case rstTransition:
+			buf.WriteString("<hr>\n")
+		}
+	}
+}
+
+// writeItem writes the content of a list item; an item made of a single
+// paragraph is written without the <p> tag.
+func (doc *rstDocument) writeItem(buf *bytes.Buffer, nodes []*rstNode) {
+	if len(nodes) == 1 && nodes[0].kind == rstParagraph {
+		buf.WriteString(doc.inline(nodes[0].text, false))
+		return
+	}
+	doc.writeHTML(buf, nodes)
+}
+
+func (doc *rstDocument) writeText(buf *bytes.Buffer, nodes []*rstNode) {
+	for _, node := range nodes {
+		switch node.kind {
+		case rstSection, rstParagraph:
+			buf.WriteString(doc.inline(node.text, true))
+			buf.WriteString("\n\n")
+		case rstLiteral, rstCode:
+			buf.WriteString(node.text)
+			buf.WriteString("\n\n")
+		case rstBulletList, rstEnumList, rstQuote:
+			for _, item := range node.children {
+				if item.kind == rstParagraph && item.children != nil {
+					doc.writeText(buf, item.children)
+				} else {
+					doc.writeText(buf, []*rstNode{item})
+				}
+			}
+		case rstDefList, rstFieldList:
+			for _, item := range node.children {
+				buf.WriteString(doc.inline(item.text, true))
+				buf.WriteString("\n")
+				doc.writeText(buf, item.children)
+			}
+		case rstAdmonition:
+			buf.WriteString(node.title)
+			buf.WriteString("\n")
+			doc.writeText(buf, node.children)
+		case rstImage:
+			if node.title != "" {
+				buf.WriteString(node.title)
+				buf.WriteString("\n\n")
+			}
+			doc.writeText(buf, node.children)
+		case rstTable:
+			for _, row := range node.rows {
+				var cells []string
+				for _, cell := range row {
+					cells = append(cells, doc.inline(cell, true))
+				}
+				buf.WriteString(strings.Join(cells, " "))
+				buf.WriteString("\n")
+			}
+			buf.WriteString("\n")
+		}
+	}
+}
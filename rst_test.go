@@ -0,0 +1,77 @@
+package spock
+
+import (
+	"strings"
+	"testing"
+)
+
+func checkRst(t *testing.T, source string, expected ...string) {
+	html, err := renderRstNative([]byte(source))
+	checkFatal(t, err)
+	for _, s := range expected {
+		if !strings.Contains(string(html), s) {
+			t.Errorf("rendered rst should contain %q:\n%s", s, html)
+		}
+	}
+}
+
+func TestRstSections(t *testing.T) {
+	checkRst(t, "=====\nTitle\n=====\n\nSection\n-------\n\nText.\n\nOther\n-------\n",
+		"<h1>Title</h1>", "<h2>Section</h2>", "<p>Text.</p>", "<h2>Other</h2>")
+	checkRst(t, "One\n\n----\n\nTwo\n", "<p>One</p>\n<hr>\n<p>Two</p>")
+}
+
+func TestRstLists(t *testing.T) {
+	checkRst(t, "- one\n- two\n\n  continued\n\n* other\n",
+		"<ul>\n<li>one</li>\n<li><p>two</p>\n<p>continued</p>\n</li>\n</ul>\n<ul>\n<li>other</li>")
+	checkRst(t, "3. three\n4. four\n", `<ol start="3">`, "<li>four</li>")
+	checkRst(t, "term\n   definition\n", "<dt>term</dt>\n<dd>definition</dd>")
+	checkRst(t, ":Author: me\n:Version: 1\n", `<dl class="field-list">`, "<dt>Version</dt>\n<dd>1</dd>")
+}
+
+func TestRstLiteralBlocks(t *testing.T) {
+	checkRst(t, "Example::\n\n    if a < b:\n        pass\n\nEnd.\n",
+		"<p>Example:</p>", "<pre><code>if a &lt; b:\n    pass</code></pre>", "<p>End.</p>")
+	checkRst(t, ".. code-block:: go\n   :linenos:\n\n   fmt.Println(\"hi\")\n",
+		`<pre><code class="language-go">fmt.Println(&#34;hi&#34;)</code></pre>`)
+	checkRst(t, "  quoted\n", "<blockquote>\n<p>quoted</p>\n</blockquote>")
+}
+
+func TestRstDirectives(t *testing.T) {
+	checkRst(t, ".. note:: Be careful.\n\n   Really.\n",
+		`<div class="admonition note">`, `<p class="admonition-title">Note</p>`, "<p>Be careful.</p>", "<p>Really.</p>")
+	checkRst(t, ".. image:: /img/logo.png\n   :alt: logo\n", `<img src="/img/logo.png" alt="logo">`)
+	checkRst(t, ".. contents::\n\n.. a comment\n\nText\n", "<p>Text</p>")
+}
+
+func TestRstInline(t *testing.T) {
+	checkRst(t, "Some **bold**, *emphasis* and ``code <b>``.",
+		"<strong>bold</strong>", "<em>emphasis</em>", "<code>code &lt;b&gt;</code>")
+	checkRst(t, "See `Go <https://golang.org>`_ or https://example.com/a.",
+		`<a href="https://golang.org">Go</a>`, `<a href="https://example.com/a">https://example.com/a</a>.`)
+	checkRst(t, "Read the docs_ and `the wiki`_.\n\n.. _docs: https://docs.example.com\n.. _the wiki: /index\n",
+		`<a href="https://docs.example.com">docs</a>`, `<a href="/index">the wiki</a>`)
+	checkRst(t, "Use :code:`x` in a_b_ names and 2*3*4.", "<code>x</code>", "a_b_ names and 2*3*4.")
+}
+
+func TestRstTables(t *testing.T) {
+	checkRst(t, "+-----+-----+\n| a   | b   |\n+=====+=====+\n| 1   | 2   |\n+-----+-----+\n",
+		"<tr><th>a</th><th>b</th></tr>", "<tr><td>1</td><td>2</td></tr>")
+	checkRst(t, "===  ===\nA    B\n===  ===\n1    2\n3    4\n===  ===\n",
+		"<tr><th>A</th><th>B</th></tr>", "<tr><td>3</td><td>4</td></tr>")
+
+	// the columns are counted in runes, not bytes
+	checkRst(t, "+-----+-----+\n| è   | à   |\n+=====+=====+\n| né  | più |\n+-----+-----+\n",
+		"<tr><th>è</th><th>à</th></tr>", "<tr><td>né</td><td>più</td></tr>")
+	checkRst(t, "=====  ===\nCittà  Già\n=====  ===\nRoma   sì\n=====  ===\n",
+		"<tr><th>Città</th><th>Già</th></tr>", "<tr><td>Roma</td><td>sì</td></tr>")
+}
+
+func TestRstPlain(t *testing.T) {
+	txt, err := renderRstNativePlain([]byte("Title\n=====\n\nSome **bold** text.\n\n.. note:: careful\n"))
+	checkFatal(t, err)
+	expected := "Title\n\nSome bold text.\n\nNote\ncareful\n\n"
+	if string(txt) != expected {
+		t.Fatalf("plain text should be %q, is %q", expected, txt)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// Sanitisation of the rendered pages: anyone can edit the wiki, so the HTML
+// written in the pages (or produced by the renderers) is filtered with an
+// allow-list before being shown.
+
+import (
+	"fmt"
+	"github.com/microcosm-cc/bluemonday"
+	"regexp"
+	"strings"
+)
+
+var (
+	sanitizePolicy = newSanitizePolicy(SanitizeConfig{})
+	// directories whose pages are not sanitised.
+	trustedDirectories []string
+	sanitizeDisabled   bool
+	// incremented by ConfigureSanitizer, so that the render cache doesn't
+	// return pages sanitised with another configuration.
+	sanitizeGeneration int
+)
+
+// the CSS properties written by the syntax highlighter.
+var highlightStyles = []string{
+	"color", "background-color", "font-weight", "font-style", "text-decoration",
+	"display", "width", "min-width", "margin", "padding", "border", "border-spacing",
+	"border-collapse", "overflow", "white-space", "vertical-align", "line-height",
+	"tab-size", "-moz-tab-size", "user-select", "-webkit-user-select",
+}
+
+// style values without quotes, colons or semicolons, e.g. "#f8f8f8" or
+// "0 0.4em 0 0.4em".
+var safeStyleRe = regexp.MustCompile(`^[\w\s#%.,()-]+$`)
+
+// the elements and attributes of the SVG images made by the diagram tools.
+var (
+	svgElements = []string{
+		"svg", "g", "defs", "title", "desc", "path", "polygon", "polyline", "line",
+		"rect", "circle", "ellipse", "text", "tspan", "marker",
+	}
+	svgAttributes = []string{
+		"version", "viewbox", "width", "height", "preserveaspectratio",
+		"transform", "d", "points", "x", "y", "x1", "y1", "x2", "y2", "cx", "cy",
+		"r", "rx", "ry", "dx", "dy", "fill", "fill-opacity", "stroke",
+		"stroke-width", "stroke-opacity", "stroke-dasharray", "stroke-linecap",
+		"stroke-linejoin", "opacity", "font-family", "font-size", "font-weight",
+		"font-style", "text-anchor", "dominant-baseline", "refx", "refy",
+		"markerwidth", "markerheight", "orient", "marker-start", "marker-end",
+	}
+)
+
+// newSanitizePolicy returns the allow-list used to sanitise the pages: the
+// user generated content policy of bluemonday, plus what's needed by the
+// table of contents, the footnotes, the syntax highlighter, the diagrams
+// and the "new-page" links, plus what's allowed by the configuration.
+func newSanitizePolicy(cfg SanitizeConfig) *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// links to the wiki pages are not "nofollow".
+	p.RequireNoFollowOnLinks(false)
+	p.RequireNoFollowOnFullyQualifiedLinks(true)
+
+	p.AllowStyling()
+	p.AllowElements("nav")
+	p.AllowAttrs("rel").Matching(regexp.MustCompile(`^footnote$`)).OnElements("a")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^(|checked|disabled)$`)).OnElements("input")
+	p.AllowStyles(highlightStyles...).Matching(safeStyleRe).OnElements("div", "pre", "code", "span", "table", "tr", "td")
+
+	p.AllowElements(svgElements...)
+	p.AllowAttrs(svgAttributes...).Matching(safeStyleRe).OnElements(svgElements...)
+	p.AllowAttrs("xmlns").OnElements("svg")
+
+	if len(cfg.AllowElements) > 0 {
+		p.AllowElements(cfg.AllowElements...)
+	}
+	if len(cfg.AllowAttributes) > 0 {
+		p.AllowAttrs(cfg.AllowAttributes...).Globally()
+	}
+	return p
+}
+
+// ConfigureSanitizer sets the allow-list and the trusted directories used
+// to sanitise the pages.
+func ConfigureSanitizer(cfg SanitizeConfig) {
+	sanitizePolicy = newSanitizePolicy(cfg)
+	sanitizeDisabled = cfg.Disabled
+	sanitizeGeneration++
+	trustedDirectories = nil
+	for _, dir := range cfg.TrustedDirectories {
+		trustedDirectories = append(trustedDirectories, cleanTreePath(dir))
+	}
+}
+
+// isTrustedPage returns true if the page found at pagepath, a path relative
+// to the root of the wiki, is in a trusted directory or in one of its
+// subdirectories.
+func isTrustedPage(pagepath string) bool {
+	pagepath = cleanTreePath(pagepath)
+	for _, dir := range trustedDirectories {
+		if dir == "" || strings.HasPrefix(pagepath, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeState describes how the page found at pagepath is sanitised; it's
+// part of the key of the render cache.
+func sanitizeState(pagepath string) string {
+	if sanitizeDisabled || isTrustedPage(pagepath) {
+		return "raw"
+	}
+	return fmt.Sprintf("sanitized%d", sanitizeGeneration)
+}
+
+// sanitizeHTML filters the rendered content of the page found at pagepath.
+func sanitizeHTML(pagepath string, content []byte) []byte {
+	if sanitizeDisabled || isTrustedPage(pagepath) {
+		return content
+	}
+	return sanitizePolicy.SanitizeBytes(content)
+}
@@ -0,0 +1,113 @@
+package spock
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	page := NewPage("notes/unsafe.md")
+	checkFatal(t, page.SetRawBytes([]byte("# Title\n\n<script>alert(1)</script>\n\n"+
+		"<a href=\"javascript:alert(1)\" onclick=\"alert(1)\">click</a> [[Other Page]]\n\n"+
+		"```go\nfunc main() {}\n```\n\n- [ ] todo\n")))
+	html, err := page.Render(nil)
+	checkFatal(t, err)
+	for _, s := range []string{"<script", "javascript:", "onclick"} {
+		if strings.Contains(string(html), s) {
+			t.Errorf("%q should be removed:\n%s", s, html)
+		}
+	}
+	for _, s := range []string{`<nav>`, `<h1 id="toc_0">`, `href="#toc_0"`, `href="Other-Page"`, `<span style="color:`} {
+		if !strings.Contains(string(html), s) {
+			t.Errorf("%q should be kept:\n%s", s, html)
+		}
+	}
+
+	checks := []string{
+		// footnotes
+		`<sup class="footnote-ref" id="fnref:1"><a href="#fn:1" rel="footnote">1</a></sup>`,
+		// org checkboxes
+		`<li><input type="checkbox" checked="" disabled=""> done</li>`,
+		// diagrams
+		`<div class="diagram diagram-dot"><svg width="62pt" height="44pt" viewbox="0.00 0.00 62.00 44.00" xmlns="http://www.w3.org/2000/svg">` +
+			`<g id="graph0" class="graph" transform="scale(1 1) rotate(0) translate(4 40)"><title>G</title>` +
+			`<polygon fill="white" stroke="none" points="-4,4 -4,-40 58,-40 58,4 -4,4"></polygon>` +
+			`<text text-anchor="middle" x="27" y="-14.3" font-family="Times,serif" font-size="14.00">a</text></g></svg></div>`,
+	}
+	for _, s := range checks {
+		if result := string(sanitizeHTML("index.md", []byte(s))); result != s {
+			t.Errorf("expected %q, got %q", s, result)
+		}
+	}
+
+	defer ConfigureSanitizer(SanitizeConfig{})
+	ConfigureSanitizer(SanitizeConfig{TrustedDirectories: []string{"/trusted"}, AllowElements: []string{"marquee"}})
+	unsafe := "<script>alert(1)</script>"
+	if result := string(sanitizeHTML("trusted/sub/page.md", []byte(unsafe))); result != unsafe {
+		t.Errorf("pages in trusted directories should not be sanitised: %q", result)
+	}
+	if result := string(sanitizeHTML("trustedpage.md", []byte(unsafe))); result != "" {
+		t.Errorf("pages outside trusted directories should be sanitised: %q", result)
+	}
+	if result := string(sanitizeHTML("page.md", []byte("<marquee>hi</marquee>"))); result != "<marquee>hi</marquee>" {
+		t.Errorf("marquee should be allowed: %q", result)
+	}
+}
+
+func TestSanitizeCachedPages(t *testing.T) {
+	defer ConfigureSanitizer(SanitizeConfig{})
+	ConfigureSanitizer(SanitizeConfig{TrustedDirectories: []string{"trusted"}})
+
+	// the same file in a trusted and in an untrusted directory
+	rc := NewRenderCache(10)
+	content := []byte("<script>alert(1)</script>\n")
+	for _, pagepath := range []string{"trusted/page.md", "notes/page.md"} {
+		page := NewPage(pagepath)
+		checkFatal(t, page.SetRawBytes(content))
+		html, err := rc.Render(page, nil)
+		checkFatal(t, err)
+		if trusted := isTrustedPage(pagepath); strings.Contains(string(html), "<script>") != trusted {
+			t.Errorf("%s (trusted: %v): %s", pagepath, trusted, html)
+		}
+	}
+
+	// a page cached before trusting its directory is sanitised again
+	page := NewPage("notes/page.md")
+	checkFatal(t, page.SetRawBytes(content))
+	ConfigureSanitizer(SanitizeConfig{TrustedDirectories: []string{"notes"}})
+	html, err := rc.Render(page, nil)
+	checkFatal(t, err)
+	if !strings.Contains(string(html), "<script>") {
+		t.Errorf("the page should not be sanitised: %s", html)
+	}
+}
+
+func TestEditPageEscapesContent(t *testing.T) {
+	var tpl []byte
+	for _, name := range []string{"edit_page.html", "_extra.html"} {
+		data, err := ioutil.ReadFile(filepath.Join("data", "templates", name))
+		checkFatal(t, err)
+		tpl = append(tpl, data...)
+	}
+	funcMap := template.FuncMap{
+		"formatDatetime": formatDatetime,
+		"gravatarHash":   gravatarHash,
+		"reverse":        func(name string, params ...interface{}) string { return "/" },
+	}
+	tmpl := template.Must(template.New("edit_page.html").Funcs(funcMap).Parse(string(tpl)))
+
+	// the source of a page is never trusted, even by the editor, and its
+	// entities must survive the round trip through the textarea.
+	var buf bytes.Buffer
+	checkFatal(t, tmpl.ExecuteTemplate(&buf, "content", map[string]interface{}{
+		"pageName": "unsafe",
+		"content":  "</textarea><script>alert(1)</script> a &lt; b",
+	}))
+	if !strings.Contains(buf.String(), "&lt;/textarea&gt;&lt;script&gt;alert(1)&lt;/script&gt; a &amp;lt; b</textarea>") {
+		t.Fatalf("the page source should be escaped:\n%s", buf.String())
+	}
+}
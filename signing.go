@@ -0,0 +1,203 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// OpenPGP signatures of the commits made by the wiki.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/openpgp"
+	"gopkg.in/libgit2/git2go.v23"
+	"os"
+	"sort"
+	"strings"
+)
+
+var gpgsigHeader = []byte("gpgsig ")
+
+// CommitSigner signs commits with an OpenPGP private key and verifies commit
+// signatures against a keyring of trusted keys.
+type CommitSigner struct {
+	entity  *openpgp.Entity
+	keyring openpgp.EntityList
+}
+
+func readKeyRing(filename string) (openpgp.EntityList, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return openpgp.ReadArmoredKeyRing(file)
+}
+
+// NewCommitSigner loads the armored private key found in keyFile, unlocking
+// it with passphrase, and the armored public keys found in trustedKeysFile;
+// both files are optional but at least one must be specified. The signing
+// key is always trusted.
+func NewCommitSigner(keyFile, passphrase, trustedKeysFile string) (*CommitSigner, error) {
+	cs := &CommitSigner{}
+
+	if keyFile != "" {
+		entities, err := readKeyRing(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, entity := range entities {
+			if entity.PrivateKey != nil {
+				cs.entity = entity
+				break
+			}
+		}
+		if cs.entity == nil {
+			return nil, fmt.Errorf("no private key found in %s", keyFile)
+		}
+		if err = decryptEntity(cs.entity, passphrase); err != nil {
+			return nil, err
+		}
+		cs.keyring = append(cs.keyring, cs.entity)
+	}
+
+	if trustedKeysFile != "" {
+		entities, err := readKeyRing(trustedKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		cs.keyring = append(cs.keyring, entities...)
+	}
+
+	if len(cs.keyring) == 0 {
+		return nil, errors.New("no signing key nor trusted keys configured")
+	}
+
+	return cs, nil
+}
+
+func decryptEntity(entity *openpgp.Entity, passphrase string) error {
+	if entity.PrivateKey.Encrypted {
+		if err := entity.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+			return err
+		}
+	}
+	for _, subkey := range entity.Subkeys {
+		if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+			if err := subkey.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CanSign returns true if a private key was loaded.
+func (cs *CommitSigner) CanSign() bool {
+	return cs.entity != nil
+}
+
+// Sign returns the armored detached signature of payload.
+func (cs *CommitSigner) Sign(payload []byte) (string, error) {
+	var out bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&out, cs.entity, bytes.NewReader(payload), nil); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// Verify checks an armored detached signature of payload, returning the
+// identity of the trusted key that made it.
+func (cs *CommitSigner) Verify(payload []byte, signature string) (string, error) {
+	entity, err := openpgp.CheckArmoredDetachedSignature(cs.keyring, bytes.NewReader(payload), strings.NewReader(signature))
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+	for name := range entity.Identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		return names[0], nil
+	}
+	return fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint), nil
+}
+
+// formatGitSignature formats an author or committer line of a commit.
+func formatGitSignature(sig *git.Signature) string {
+	return fmt.Sprintf("%s <%s> %d %s", sig.Name, sig.Email, sig.When.Unix(), sig.When.Format("-0700"))
+}
+
+// buildCommitObject returns the content of a commit object, as written by
+// git.
+func buildCommitObject(tree *git.Oid, parents []*git.Oid, author, committer *git.Signature, message string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "tree %s\n", tree)
+	for _, parent := range parents {
+		fmt.Fprintf(&buf, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&buf, "author %s\n", formatGitSignature(author))
+	fmt.Fprintf(&buf, "committer %s\n", formatGitSignature(committer))
+	buf.WriteString("\n")
+	buf.WriteString(message)
+	return buf.Bytes()
+}
+
+// addCommitSignature adds the "gpgsig" header to a commit object; the
+// header value spans multiple lines, each continuation line starting with
+// a space.
+func addCommitSignature(payload []byte, signature string) []byte {
+	end := bytes.Index(payload, []byte("\n\n"))
+	if end == -1 {
+		end = len(payload) - 1
+	}
+
+	var buf bytes.Buffer
+	buf.Write(payload[:end+1])
+	buf.Write(gpgsigHeader)
+	buf.WriteString(strings.Replace(strings.TrimRight(signature, "\n"), "\n", "\n ", -1))
+	buf.WriteString("\n")
+	buf.Write(payload[end+1:])
+	return buf.Bytes()
+}
+
+// splitCommitSignature extracts the "gpgsig" header from a commit object,
+// returning the signed payload and the signature.
+func splitCommitSignature(data []byte) ([]byte, string, bool) {
+	end := bytes.Index(data, []byte("\n\n"))
+	if end == -1 {
+		end = len(data) - 1
+	}
+	headers := data[:end+1]
+
+	start := bytes.Index(headers, append([]byte("\n"), gpgsigHeader...))
+	if start == -1 {
+		return data, "", false
+	}
+	start++
+
+	// the header ends at the first line not starting with a space
+	stop := start
+	for {
+		eol := bytes.IndexByte(headers[stop:], '\n')
+		if eol == -1 {
+			stop = len(headers)
+			break
+		}
+		stop += eol + 1
+		if stop >= len(headers) || headers[stop] != ' ' {
+			break
+		}
+	}
+
+	signature := string(headers[start+len(gpgsigHeader) : stop])
+	signature = strings.Replace(signature, "\n ", "\n", -1)
+
+	var payload []byte
+	payload = append(payload, data[:start]...)
+	payload = append(payload, data[stop:]...)
+	return payload, signature, true
+}
@@ -0,0 +1,61 @@
+package spock
+
+import (
+	"golang.org/x/crypto/openpgp"
+	"gopkg.in/libgit2/git2go.v23"
+	"testing"
+	"time"
+)
+
+func createTestSigner(t *testing.T) *CommitSigner {
+	entity, err := openpgp.NewEntity("Spock Wiki", "", "wiki@example.com", nil)
+	checkFatal(t, err)
+	return &CommitSigner{entity: entity, keyring: openpgp.EntityList{entity}}
+}
+
+func TestCommitSignatureRoundTrip(t *testing.T) {
+	signer := createTestSigner(t)
+
+	tree, err := git.NewOid("4b825dc642cb6eb9a060e54bf8d69288fbee4904")
+	checkFatal(t, err)
+	sig := &git.Signature{Name: "Test User", Email: "test@example.com", When: time.Unix(1408911120, 0).UTC()}
+	payload := buildCommitObject(tree, nil, sig, sig, "import index.md")
+
+	signature, err := signer.Sign(payload)
+	checkFatal(t, err)
+	commit := addCommitSignature(payload, signature)
+
+	extracted, extractedSig, signed := splitCommitSignature(commit)
+	if !signed {
+		t.Fatal("the commit should be signed")
+	}
+	if string(extracted) != string(payload) {
+		t.Fatalf("payload should be:\n%s\nis:\n%s", payload, extracted)
+	}
+
+	signedBy, err := signer.Verify(extracted, extractedSig)
+	checkFatal(t, err)
+	if signedBy != "Spock Wiki <wiki@example.com>" {
+		t.Fatalf("signer should be \"Spock Wiki <wiki@example.com>\", is \"%s\"", signedBy)
+	}
+
+	if _, err = signer.Verify([]byte("tampered"), extractedSig); err == nil {
+		t.Fatal("a tampered payload should not be verified")
+	}
+}
+
+func TestSignedCommit(t *testing.T) {
+	gs := createTestRepo(t)
+	defer cleanup(t, gs)
+
+	gs.SetCommitSigner(createTestSigner(t))
+	pageName := createIndexPage(t, gs)
+	_, err := gs.CommitFile(pageName, createSignature(t), "import index.md")
+	checkFatal(t, err)
+
+	logs, err := gs.LogsForPage(pageName)
+	checkFatal(t, err)
+	if len(logs) != 1 || !logs[0].SignatureValid {
+		t.Fatalf("the commit should carry a valid signature: %+v", logs)
+	}
+}
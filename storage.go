@@ -15,7 +15,8 @@ var PAGE_EXTENSIONS = []string{"md", "rst", "org", "txt"}
 
 // This is the interface to the version control system used as a backend.
 type Storage interface {
-	JoinPath(relpath string) (string, error)
+	// Read a file, returning its content and modification time.
+	ReadFile(relpath string) ([]byte, time.Time, error)
 
 	// Lookup a single Page
 	LookupPage(pagepath string) (*Page, bool, error)
@@ -23,7 +24,7 @@ type Storage interface {
 	// CRUD
 	RenamePage(origPath, destPath string, signature *CommitSignature, message string) (RevID, error)
 	DeletePage(path string, signature *CommitSignature, message string) (RevID, error)
-	SavePage(page *Page, sig *CommitSignature, message string) error
+	SavePage(page *Page, sig *CommitSignature, message string) (RevID, error)
 
 	// Get the commit logs for a Page.
 	LogsForPage(path string) ([]CommitLog, error)
@@ -36,8 +37,35 @@ type Storage interface {
 
 	// Returns a diff between the current page content and another revision. (rewrite?)
 	DiffPage(page *Page, revA, revB string) ([]string, error)
+
+	// Call a function for every file inside a directory, at a given revision.
+	WalkFiles(rev, dir string, fn WalkFilesFunc) error
+
+	// List the pages that were deleted and never recreated.
+	DeletedPages() ([]DeletedPage, error)
+
+	// Recreate a deleted page with the content it had before revision "rev".
+	RestorePage(path, rev string, signature *CommitSignature, message string) (RevID, error)
+
+	// Restore the content that a page had at revision "rev".
+	RevertPage(path, rev string, signature *CommitSignature, message string) (RevID, error)
+
+	// Returns the patch introduced by a single commit.
+	CommitDiff(rev RevID) (string, error)
+
+	// The branch the wiki commits to, and all the branches.
+	CurrentBranch() (string, error)
+	Branches() ([]string, error)
+
+	// Read only access to another revision or branch.
+	LookupPageAt(rev, pagepath string) (*Page, bool, error)
+	ListPagesAt(rev string) ([]string, error)
 }
 
+// WalkFilesFunc is the type of the function called by Storage.WalkFiles for
+// each file; returning an error stops the walk.
+type WalkFilesFunc func(path string, data []byte) error
+
 // The struct used to pack all informations regarding a single VCS commit.
 type CommitLog struct {
 	Id      string
@@ -45,6 +73,19 @@ type CommitLog struct {
 	Name    string
 	Email   string
 	When    time.Time
+
+	// OpenPGP signature: SignatureValid is true when the signature was
+	// made by a trusted key, identified by SignedBy.
+	Signed         bool
+	SignatureValid bool
+	SignedBy       string
+}
+
+// DeletedPage is a page removed from the wiki; Path is the page filename and
+// Commit is the commit that deleted it.
+type DeletedPage struct {
+	Path   string
+	Commit CommitLog
 }
 
 // The struct used when creating a new commit
@@ -0,0 +1,142 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// Checks run on a page before saving it.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Maximum running time of a lint command.
+var lintTimeout = 10 * time.Second
+
+// A Validator checks a page before it's saved, returning an error
+// describing why the page was rejected.
+type Validator interface {
+	Validate(page *Page) error
+}
+
+// ValidatorFunc is an adapter to use ordinary functions as Validators.
+type ValidatorFunc func(page *Page) error
+
+func (f ValidatorFunc) Validate(page *Page) error {
+	return f(page)
+}
+
+// NewValidators creates the Validators enabled in the configuration.
+func NewValidators(cfg *ValidationConfig) ([]Validator, error) {
+	var validators []Validator
+
+	if cfg.RequireTitle {
+		validators = append(validators, ValidatorFunc(requireTitle))
+	}
+	if len(cfg.Languages) > 0 {
+		validators = append(validators, allowedLanguages(cfg.Languages))
+	}
+	if cfg.MaxSize > 0 {
+		validators = append(validators, maxPageSize(cfg.MaxSize))
+	}
+	for _, pattern := range cfg.ForbiddenPatterns {
+		// ^ and $ match at the start and end of each line of the page
+		re, err := regexp.Compile("(?m)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid forbidden pattern %q: %s", pattern, err)
+		}
+		validators = append(validators, forbiddenPattern(re))
+	}
+	for _, cmdline := range cfg.LintCommands {
+		if len(cmdline) == 0 {
+			return nil, fmt.Errorf("empty lint command")
+		}
+		validators = append(validators, lintCommand(cmdline))
+	}
+
+	return validators, nil
+}
+
+// ValidatePage runs all the validators on page and returns the error
+// messages.
+func ValidatePage(validators []Validator, page *Page) []string {
+	var result []string
+	for _, validator := range validators {
+		if err := validator.Validate(page); err != nil {
+			result = append(result, err.Error())
+		}
+	}
+	return result
+}
+
+func requireTitle(page *Page) error {
+	if strings.TrimSpace(page.Header.Title) == "" {
+		return fmt.Errorf("The page header must contain a title")
+	}
+	return nil
+}
+
+func allowedLanguages(languages []string) ValidatorFunc {
+	return func(page *Page) error {
+		for _, language := range languages {
+			if page.Header.Language == language {
+				return nil
+			}
+		}
+		return fmt.Errorf("The page language must be one of: %s", strings.Join(languages, ", "))
+	}
+}
+
+func maxPageSize(size int) ValidatorFunc {
+	return func(page *Page) error {
+		if len(page.RawBytes) > size {
+			return fmt.Errorf("The page is too big: %d bytes, the limit is %d", len(page.RawBytes), size)
+		}
+		return nil
+	}
+}
+
+// forbiddenPattern matches re against the whole page, so that patterns can
+// span more lines.
+func forbiddenPattern(re *regexp.Regexp) ValidatorFunc {
+	return func(page *Page) error {
+		loc := re.FindIndex(page.RawBytes)
+		if loc == nil {
+			return nil
+		}
+		lineno := bytes.Count(page.RawBytes[:loc[0]], []byte("\n")) + 1
+		return fmt.Errorf("Line %d contains a forbidden pattern (%s)", lineno, strings.TrimPrefix(re.String(), "(?m)"))
+	}
+}
+
+// lintCommand runs an external command with the page on stdin; the page
+// path is passed in the SPOCK_PAGE environment variable.
+func lintCommand(cmdline []string) ValidatorFunc {
+	return func(page *Page) error {
+		ctx, cancel := context.WithTimeout(context.Background(), lintTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, cmdline[0], cmdline[1:]...)
+		cmd.Stdin = bytes.NewReader(page.RawBytes)
+		cmd.Env = append(os.Environ(), "SPOCK_PAGE="+page.Path)
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+
+		if err := cmd.Run(); err != nil {
+			output := strings.TrimSpace(out.String())
+			if output == "" {
+				output = err.Error()
+			}
+			return fmt.Errorf("%s: %s", cmdline[0], output)
+		}
+		return nil
+	}
+}
@@ -25,8 +25,11 @@ var (
 // User is a representation of a wiki user.
 type User struct {
 	Authenticated bool
-	Name          string
-	Email         string
+	// The user logged in with the password of an account, so Email has
+	// been checked.
+	Verified bool
+	Name     string
+	Email    string
 }
 
 // Alert is used to show informational messages in the web GUI.
@@ -47,6 +50,10 @@ func UserFromSession(session *sessions.Session) *User {
 		user.Authenticated = false
 	}
 
+	if verified, ok := session.Values["verified"].(bool); ok {
+		user.Verified = user.Authenticated && verified
+	}
+
 	if username, ok := session.Values["name"]; ok {
 		user.Name = username.(string)
 	} else {
@@ -63,12 +70,17 @@ func UserFromSession(session *sessions.Session) *User {
 }
 
 type AppContext struct {
+	Config       *Configuration
 	SessionStore sessions.Store
 	Storage      Storage
 	Router       *mux.Router
 	Templates    map[string]*template.Template
 	XsrfSecret   string
 	Index        Index
+	Validators   []Validator
+	Webhooks     *Webhooks
+	RenderCache  *RenderCache
+	Crypter      *PageCrypter
 }
 
 type vRequest struct {
@@ -114,6 +126,15 @@ type TemplateContext map[string]interface{}
 func newTemplateContext(r *vRequest) TemplateContext {
 	tc := make(map[string]interface{})
 	tc["user"] = r.AuthUser
+	tc["isAdmin"] = isAdmin(r)
+
+	// the branch selector is shown only when there's something to choose.
+	if branches, err := r.Ctx.Storage.Branches(); err == nil && len(branches) > 1 {
+		current, _ := r.Ctx.Storage.CurrentBranch()
+		tc["branches"] = branches
+		tc["currentBranch"] = current
+	}
+	tc["branch"] = viewedBranch(r)
 	return tc
 }
 
@@ -212,6 +233,10 @@ func loadTemplates(router *mux.Router) map[string]*template.Template {
 		"diff.html",
 		"delete.html",
 		"welcome.html",
+		"trash.html",
+		"check.html",
+		"webhooks.html",
+		"tags.html",
 	}
 	for _, tplName := range templateNames {
 		templates[tplName] = LoadRiceTemplate(tplName, &funcMap, templateBox)
@@ -239,6 +264,13 @@ func RunServer(address string, ac *AppContext) error {
 	r.Handle("/", WithRequest(ac, vHandlerFunc(IndexAllPages))).Queries("action", "index")
 	r.Handle("/", WithRequest(ac, vHandlerFunc(ListPages))).Queries("action", "ls").Name("list_pages")
 	r.Handle("/", WithRequest(ac, vHandlerFunc(SearchPages))).Queries("action", "search").Name("search_pages")
+	r.Handle("/", WithRequest(ac, vHandlerFunc(ArchivePages))).Queries("action", "archive")
+	r.Handle("/", WithRequest(ac, vHandlerFunc(ListTags))).Queries("action", "tags").Name("tags")
+	r.Handle("/", WithRequest(ac, vHandlerFunc(ShowTrash))).Queries("action", "trash").Name("trash")
+	r.Handle("/", WithRequest(ac, vHandlerFunc(UndeletePage))).Queries("action", "undelete").Name("undelete_page")
+	r.Handle("/", WithRequest(ac, vHandlerFunc(CheckWikiView))).Queries("action", "check").Name("check_wiki")
+	r.Handle("/", WithRequest(ac, vHandlerFunc(ShowWebhookDeliveries))).Queries("action", "webhooks").Name("webhooks")
+	r.Handle("/", WithRequest(ac, vHandlerFunc(SelectBranch))).Queries("action", "branch").Name("select_branch")
 	r.Handle("/", WithRequest(ac, vHandlerFunc(IndexRedirect))).Name("index")
 
 	// serve any filename ending with an extension as a binary file.
@@ -249,6 +281,8 @@ func RunServer(address string, ac *AppContext) error {
 	r.Handle(pp, WithRequest(ac, vHandlerFunc(ShowPageLog))).Queries("action", "log").Name("show_log")
 	r.Handle(pp, WithRequest(ac, vHandlerFunc(RenamePage))).Queries("action", "rename").Name("rename_page")
 	r.Handle(pp, WithRequest(ac, vHandlerFunc(DeletePage))).Queries("action", "delete").Name("delete_page")
+	r.Handle(pp, WithRequest(ac, vHandlerFunc(ArchivePages))).Queries("action", "archive").Name("archive")
+	r.Handle(pp, WithRequest(ac, vHandlerFunc(RevertPage))).Queries("action", "revert").Name("revert_page")
 	r.Handle(pp, WithRequest(ac, vHandlerFunc(DiffPage))).Queries("action", "diff", "startrev", `{startrev:[a-zA-Z0-9]{40}}`, "endrev", `{endrev:[a-zA-Z0-9]{40}}`).Name("diff_page")
 
 	r.Handle(pp, WithRequest(ac, vHandlerFunc(ShowPage))).Name("show_page")
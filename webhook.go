@@ -0,0 +1,253 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// Outgoing webhooks fired after a page is changed.
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Webhook events.
+const (
+	EventSave    = "save"
+	EventRename  = "rename"
+	EventDelete  = "delete"
+	EventRevert  = "revert"
+	EventRestore = "restore"
+)
+
+// SignatureHeader is the HTTP header containing the HMAC-SHA256 signature of
+// the payload, computed with the secret of the webhook.
+const SignatureHeader = "X-Spock-Signature"
+
+var (
+	webhookAttempts   = 5
+	webhookRetryDelay = 2 * time.Second
+	webhookTimeout    = 10 * time.Second
+	webhookQueueSize  = 100
+	webhookLogSize    = 100
+)
+
+// WebhookConfig is the configuration of a single webhook; an empty Events
+// list subscribes to every event.
+type WebhookConfig struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+func (wc *WebhookConfig) wants(event string) bool {
+	if len(wc.Events) == 0 {
+		return true
+	}
+	for _, e := range wc.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookPayload is the JSON document sent to the webhooks.
+type WebhookPayload struct {
+	Event     string    `json:"event"`
+	Path      string    `json:"path"`
+	OldPath   string    `json:"old_path,omitempty"`
+	Commit    RevID     `json:"commit"`
+	Author    string    `json:"author"`
+	Email     string    `json:"email"`
+	Message   string    `json:"message"`
+	Diff      string    `json:"diff"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// WebhookDelivery is an entry of the delivery log.
+type WebhookDelivery struct {
+	URL      string
+	Event    string
+	Path     string
+	Commit   RevID
+	Attempts int
+	Status   int
+	Error    string
+	When     time.Time
+}
+
+// Success returns true if the webhook accepted the payload.
+func (wd *WebhookDelivery) Success() bool {
+	return wd.Error == ""
+}
+
+type webhookJob struct {
+	payload *WebhookPayload
+	body    []byte
+}
+
+// Webhooks delivers the payloads to the configured webhooks; each webhook
+// has its own queue, so that a slow endpoint doesn't delay the others.
+type Webhooks struct {
+	hooks  []WebhookConfig
+	queues []chan webhookJob
+	client *http.Client
+
+	mu  sync.Mutex
+	log []WebhookDelivery
+}
+
+// NewWebhooks starts the delivery goroutines of the configured webhooks.
+func NewWebhooks(hooks []WebhookConfig) *Webhooks {
+	wh := &Webhooks{
+		hooks:  hooks,
+		client: &http.Client{Timeout: webhookTimeout},
+	}
+	for i := range hooks {
+		queue := make(chan webhookJob, webhookQueueSize)
+		wh.queues = append(wh.queues, queue)
+		go wh.deliverAll(&wh.hooks[i], queue)
+	}
+	return wh
+}
+
+// Wants returns true if at least one webhook is subscribed to event.
+func (wh *Webhooks) Wants(event string) bool {
+	if wh == nil {
+		return false
+	}
+	for i := range wh.hooks {
+		if wh.hooks[i].wants(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fire queues the payload for delivery to the webhooks subscribed to its
+// event; it never blocks.
+func (wh *Webhooks) Fire(payload *WebhookPayload) {
+	if wh == nil {
+		return
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Cannot encode webhook payload: %s\n", err)
+		return
+	}
+
+	for i := range wh.hooks {
+		if !wh.hooks[i].wants(payload.Event) {
+			continue
+		}
+		select {
+		case wh.queues[i] <- webhookJob{payload, body}:
+		default:
+			wh.record(&wh.hooks[i], payload, 0, 0, "delivery queue full")
+		}
+	}
+}
+
+// Deliveries returns the delivery log, most recent first.
+func (wh *Webhooks) Deliveries() []WebhookDelivery {
+	if wh == nil {
+		return nil
+	}
+
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	result := make([]WebhookDelivery, len(wh.log))
+	for i, delivery := range wh.log {
+		result[len(wh.log)-1-i] = delivery
+	}
+	return result
+}
+
+func (wh *Webhooks) record(hook *WebhookConfig, payload *WebhookPayload, attempts, status int, errMsg string) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	wh.log = append(wh.log, WebhookDelivery{
+		URL:      hook.URL,
+		Event:    payload.Event,
+		Path:     payload.Path,
+		Commit:   payload.Commit,
+		Attempts: attempts,
+		Status:   status,
+		Error:    errMsg,
+		When:     time.Now(),
+	})
+	if len(wh.log) > webhookLogSize {
+		wh.log = wh.log[len(wh.log)-webhookLogSize:]
+	}
+}
+
+func (wh *Webhooks) deliverAll(hook *WebhookConfig, queue chan webhookJob) {
+	for job := range queue {
+		wh.deliver(hook, job)
+	}
+}
+
+// deliver posts a payload, retrying with an exponential backoff on network
+// errors and on non 2xx responses.
+func (wh *Webhooks) deliver(hook *WebhookConfig, job webhookJob) {
+	var status int
+	var err error
+
+	delay := webhookRetryDelay
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if status, err = wh.post(hook, job.body); err == nil {
+			wh.record(hook, job.payload, attempt, status, "")
+			return
+		}
+		if attempt < webhookAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+
+	log.Printf("Webhook delivery to %s failed: %s\n", hook.URL, err)
+	wh.record(hook, job.payload, webhookAttempts, status, err.Error())
+}
+
+func (wh *Webhooks) post(hook *WebhookConfig, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "spock-webhook")
+	if hook.Secret != "" {
+		req.Header.Set(SignatureHeader, SignPayload(hook.Secret, body))
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignPayload returns the signature of body, in the "sha256=<hex digest>"
+// format used in the SignatureHeader.
+func SignPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,75 @@
+package spock
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSignPayload(t *testing.T) {
+	// echo -n 'hello' | openssl dgst -sha256 -hmac secret
+	expected := "sha256=88aab3ede8d3adf94d26ab90d3bafd4a2083070c3bcce9c014ee04a443847c0b"
+	if rv := SignPayload("secret", []byte("hello")); rv != expected {
+		t.Fatalf("signature should be %s, is %s", expected, rv)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	defer func(delay time.Duration) { webhookRetryDelay = delay }(webhookRetryDelay)
+	webhookRetryDelay = time.Millisecond
+
+	received := make(chan *WebhookPayload, 1)
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if sig := r.Header.Get(SignatureHeader); sig != SignPayload("secret", body) {
+			t.Errorf("invalid signature: %s", sig)
+		}
+		var payload WebhookPayload
+		if err = json.Unmarshal(body, &payload); err != nil {
+			t.Error(err)
+		}
+		received <- &payload
+	}))
+	defer ts.Close()
+
+	wh := NewWebhooks([]WebhookConfig{{URL: ts.URL, Secret: "secret", Events: []string{EventSave}}})
+	if wh.Wants(EventDelete) {
+		t.Fatal("webhook should not be subscribed to delete events")
+	}
+	wh.Fire(&WebhookPayload{Event: EventSave, Path: "index.md", Commit: "abc"})
+
+	select {
+	case payload := <-received:
+		if payload.Path != "index.md" {
+			t.Fatalf("path should be index.md, is %s", payload.Path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+
+	// the delivery log is updated after the response is received
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries := wh.Deliveries()
+		if len(deliveries) == 1 && deliveries[0].Attempts == 2 && deliveries[0].Success() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("there should be 1 successful delivery after 2 attempts: %+v", deliveries)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
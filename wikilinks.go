@@ -0,0 +1,154 @@
+// Copyright 2014 Daniel Kertesz <daniel@spatof.org>
+// All rights reserved. This program comes with ABSOLUTELY NO WARRANTY.
+// See the file LICENSE for details.
+
+package spock
+
+// Wiki links: [[Page Name]], [[dir/Page|label]] and [[Page#section]] are
+// rewritten as links written in the markup of the page before rendering.
+
+import (
+	"bufio"
+	"bytes"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var wikiLinkRe = regexp.MustCompile(`\[\[([^\[\]|]+)(?:\|([^\[\]]+))?\]\]`)
+
+// wikiPageName converts the target of a wiki link to a page path, without
+// the page extension (like the paths passed to LookupPage) and with dashes
+// instead of spaces.
+func wikiPageName(target string) string {
+	target = strings.TrimSpace(target)
+	if IsPageFilename(target) {
+		target = ShortenPageName(target)
+	}
+	return strings.Replace(target, " ", "-", -1)
+}
+
+// wikiPagePath returns the path, relative to the root of the wiki, of the
+// page target found in the directory dir.
+func wikiPagePath(dir, target string) string {
+	target = wikiPageName(target)
+	if !strings.HasPrefix(target, "/") {
+		target = path.Join(dir, target)
+	}
+	return cleanTreePath(target)
+}
+
+// wikiLinkHref returns the URL of the page target of a wiki link, relative
+// to the directory of the linking page (or absolute, when target starts
+// with "/").
+func wikiLinkHref(target string) string {
+	var anchor string
+	if i := strings.Index(target, "#"); i != -1 {
+		target, anchor = target[:i], target[i+1:]
+	}
+
+	u := url.URL{
+		Path:     wikiPageName(target),
+		Fragment: strings.Replace(strings.TrimSpace(anchor), " ", "-", -1),
+	}
+	// parentheses would end a Markdown link
+	href := u.String()
+	href = strings.Replace(href, "(", "%28", -1)
+	return strings.Replace(href, ")", "%29", -1)
+}
+
+// isWikiLinkTarget tells apart wiki links from the Org links sharing the
+// same syntax, like [[https://example.com]] or [[*Headline]].
+func isWikiLinkTarget(target string) bool {
+	return !strings.Contains(target, ":") && !strings.HasPrefix(target, "*")
+}
+
+// expandWikiLinksInLine rewrites the wiki links found in a line of text.
+func expandWikiLinksInLine(markup, line string) string {
+	return wikiLinkRe.ReplaceAllStringFunc(line, func(match string) string {
+		m := wikiLinkRe.FindStringSubmatch(match)
+		target, label := strings.TrimSpace(m[1]), strings.TrimSpace(m[2])
+		if !isWikiLinkTarget(target) {
+			return match
+		}
+		if label == "" {
+			label = target
+		}
+		return formatLink(markup, label, wikiLinkHref(target))
+	})
+}
+
+// expandWikiLinks rewrites the wiki links found in the content of a page
+// with the syntax of its markup, skipping code blocks and Markdown code
+// spans.
+func expandWikiLinks(markup string, content []byte) ([]byte, error) {
+	if !bytes.Contains(content, []byte("[[")) {
+		return content, nil
+	}
+	return mapTextLines(markup, content, func(line string) string {
+		if markup == markdownName {
+			return expandWikiLinksInCode(line)
+		}
+		return expandWikiLinksInLine(markup, line)
+	})
+}
+
+// mapTextLines replaces each line of content found outside of the code
+// blocks of the markup with the result of fn.
+func mapTextLines(markup string, content []byte, fn func(line string) string) ([]byte, error) {
+	var out bytes.Buffer
+	var fence string
+	literal := false
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	// no line is longer than the page (e.g. embedded images)
+	scanner.Buffer(nil, len(content)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case fence != "":
+			// inside a code block
+			if strings.HasPrefix(strings.ToUpper(trimmed), fence) {
+				fence = ""
+			}
+		case markup == markdownName && (strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")):
+			fence = trimmed[:3]
+		case markup == orgName && strings.HasPrefix(strings.ToUpper(trimmed), "#+BEGIN_"):
+			fence = "#+END_"
+		case markup == rstName && literal && (trimmed == "" || indentOf(line) > 0):
+			// reStructuredText literal blocks are indented
+		default:
+			line = fn(line)
+		}
+
+		if markup == rstName && trimmed != "" && (indentOf(line) == 0 || !literal) {
+			literal = strings.HasSuffix(trimmed, "::") || strings.HasPrefix(trimmed, ".. code")
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	result := out.Bytes()
+	if !bytes.HasSuffix(content, []byte("\n")) && len(result) > 0 {
+		result = result[:len(result)-1]
+	}
+	return result, nil
+}
+
+// expandWikiLinksInCode rewrites the wiki links of a Markdown line found
+// outside of the `code spans`.
+func expandWikiLinksInCode(line string) string {
+	parts := strings.Split(line, "`")
+	for i := range parts {
+		// the text after an unmatched backtick is not a code span
+		if i%2 == 0 || i == len(parts)-1 {
+			parts[i] = expandWikiLinksInLine(markdownName, parts[i])
+		}
+	}
+	return strings.Join(parts, "`")
+}
@@ -0,0 +1,74 @@
+package spock
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWikiLinkHref(t *testing.T) {
+	tests := map[string]string{
+		"Page Name":         "Page-Name",
+		"dir/Other Page.md": "dir/Other-Page",
+		"/notes/Linux Tips": "/notes/Linux-Tips",
+		"Page#Some section": "Page#Some-section",
+		"#top":              "#top",
+		"C++ (draft)":       "C++-%28draft%29",
+		"../Up":             "../Up",
+		"Caffè?":            "Caff%C3%A8%3F",
+	}
+	for target, expected := range tests {
+		if href := wikiLinkHref(target); href != expected {
+			t.Errorf("href of %q should be %q, is %q", target, expected, href)
+		}
+	}
+}
+
+func TestExpandWikiLinks(t *testing.T) {
+	md := "See [[Page Name]] and [[dir/Page|the page]].\n\n```\n[[code]]\n```\n\nNot `[[this]]` but [[that]]\n"
+	expected := "See [Page Name](Page-Name) and [the page](dir/Page).\n\n```\n[[code]]\n```\n\nNot `[[this]]` but [that](that)\n"
+	rv, err := expandWikiLinks(markdownName, []byte(md))
+	checkFatal(t, err)
+	if string(rv) != expected {
+		t.Errorf("markdown should be %q, is %q", expected, rv)
+	}
+
+	rst := "A [[Page]] link::\n\n    [[literal]]\n\nEnd [[Other#x|other]]"
+	expected = "A `Page <Page>`__ link::\n\n    [[literal]]\n\nEnd `other <Other#x>`__"
+	rv, err = expandWikiLinks(rstName, []byte(rst))
+	checkFatal(t, err)
+	if string(rv) != expected {
+		t.Errorf("rst should be %q, is %q", expected, rv)
+	}
+
+	org := "[[Page]] [[https://example.com]] [[*Headline]] [[https://x.org][x]]\n#+BEGIN_SRC\n[[src]]\n#+END_SRC\n"
+	expected = "[[Page][Page]] [[https://example.com]] [[*Headline]] [[https://x.org][x]]\n#+BEGIN_SRC\n[[src]]\n#+END_SRC\n"
+	rv, err = expandWikiLinks(orgName, []byte(org))
+	checkFatal(t, err)
+	if string(rv) != expected {
+		t.Errorf("org should be %q, is %q", expected, rv)
+	}
+}
+
+func TestExpandWikiLinksLongLines(t *testing.T) {
+	// e.g. an embedded image
+	image := "![logo](data:image/png;base64," + strings.Repeat("A", 100*1024) + ")"
+	md := "[[First]]\n" + image + "\n[[Last]]\n"
+	expected := "[First](First)\n" + image + "\n[Last](Last)\n"
+	rv, err := expandWikiLinks(markdownName, []byte(md))
+	checkFatal(t, err)
+	if string(rv) != expected {
+		t.Errorf("the lines after a long line should be kept, the page is %d bytes long instead of %d", len(rv), len(expected))
+	}
+}
+
+func TestRenderWikiLinks(t *testing.T) {
+	for _, filename := range []string{"page.md", "page.rst", "page.org"} {
+		page := NewPage(filename)
+		checkFatal(t, page.SetRawBytes([]byte("A link to [[Other Page|the other page]].\n")))
+		html, err := page.Render(nil)
+		checkFatal(t, err)
+		if !strings.Contains(string(html), `<a href="Other-Page">the other page</a>`) {
+			t.Errorf("%s: the wiki link was not rendered: %s", filename, html)
+		}
+	}
+}